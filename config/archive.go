@@ -0,0 +1,109 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"recurbate/ts"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Defines the archive of completed downloads, each line holds a video id or a filename
+type Archive struct {
+	path    string
+	entries map[string]bool
+	mtx     sync.Mutex
+}
+
+// matches the collision suffix Mux adds to filenames
+var collisionRegex = regexp.MustCompile(`\(\d+\)$`)
+
+// Loads the archive file, a missing file is empty
+func LoadArchive(path string) (archive *Archive, err error) {
+	archive = &Archive{
+		path:    path,
+		entries: make(map[string]bool),
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return archive, nil
+	} else if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			archive.entries[line] = true
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// Returns whether any of the keys is archived
+func (archive *Archive) Has(keys ...string) bool {
+	if archive == nil {
+		return false
+	}
+	archive.mtx.Lock()
+	defer archive.mtx.Unlock()
+	for _, key := range keys {
+		if key != "" && archive.entries[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// Appends the keys not yet archived to the archive file
+func (archive *Archive) Add(keys ...string) (err error) {
+	if archive == nil {
+		return
+	}
+	archive.mtx.Lock()
+	defer archive.mtx.Unlock()
+	file, err := os.OpenFile(archive.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return fmt.Errorf("error: Saving Archive: %v", err)
+	}
+	defer file.Close()
+	for _, key := range keys {
+		if key == "" || archive.entries[key] {
+			continue
+		}
+		_, err = fmt.Fprintln(file, key)
+		if err != nil {
+			return fmt.Errorf("error: Saving Archive: %v", err)
+		}
+		archive.entries[key] = true
+	}
+	return
+}
+
+// Adds every completed .ts file in the directory and its subdirectories to the archive, a .ts
+// is complete once its segment index is marked as ended. The video id is added too when the
+// index records it, so the video is skipped before a view is used
+func (archive *Archive) Rebuild(dir string) (added int, err error) {
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) != ".ts" {
+			return err
+		}
+		id, complete, err := ts.ReadIndexVideo(ts.IndexPath(path))
+		if err != nil || !complete {
+			return nil
+		}
+		filename := collisionRegex.ReplaceAllString(strings.TrimSuffix(filepath.Base(path), ".ts"), "")
+		if archive.Has(filename) && (id == "" || archive.Has(id)) {
+			return nil
+		}
+		added++
+		return archive.Add(id, filename)
+	})
+	return
+}
@@ -1,13 +1,20 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"recurbate/filter"
+	"recurbate/hooks"
+	"recurbate/metrics"
 	"recurbate/playlist"
 	"recurbate/recu"
 	"recurbate/tools"
+	"strings"
 	"sync"
+	"time"
 )
 
 // mutex
@@ -17,59 +24,141 @@ var (
 
 // Defines the JSON used
 type Config struct {
-	Urls   []any             `json:"urls"`
-	Header map[string]string `json:"header"`
+	Urls           []any                   `json:"urls"`
+	Header         map[string]string       `json:"header"`
+	Info           map[string]recu.Listing `json:"info,omitempty"`
+	Filter         []string                `json:"filter,omitempty"`
+	Follow         []string                `json:"follow,omitempty"`
+	FollowInterval string                  `json:"follow_interval,omitempty"`
+	Archive        string                  `json:"archive,omitempty"`
+	Existing       string                  `json:"existing,omitempty"`
+	Store          string                  `json:"store,omitempty"`
+	Filename       string                  `json:"filename,omitempty"`
+	Directory      string                  `json:"directory,omitempty"`
+	Incomplete     string                  `json:"incomplete,omitempty"`
+	Complete       string                  `json:"complete,omitempty"`
+	Part           bool                    `json:"part,omitempty"`
+	MinFree        string                  `json:"min_free,omitempty"`
+	Hooks          *hooks.Hooks            `json:"hooks,omitempty"`
+	Webhooks       []hooks.Webhook         `json:"webhooks,omitempty"`
+	filters        []filter.Filter
+	archive        *Archive
+	// the json as it was last read or saved, Save only writes the values changed since
+	base *snapshot
+}
+
+// Defines the json as this process last read or wrote it, shared by every copy of the config
+type snapshot struct {
+	data []byte
 }
 
 // Gets Playlist
 func (config Config) GetPlaylist(urlAny any, jsonLoc int) (playList playlist.Playlist) {
+	playList, _ = config.FetchPlaylist(urlAny, jsonLoc)
+	return
+}
+
+// Gets Playlist, errors are printed and sent to the hooks and webhooks before being returned
+func (config Config) FetchPlaylist(urlAny any, jsonLoc int) (playList playlist.Playlist, err error) {
+	url := EntryUrl(urlAny)
+	tools.Emit(tools.EventResolveStart, map[string]any{"url": url})
+	start := time.Now()
+	playList, err = config.ResolvePlaylist(urlAny, jsonLoc)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		event := "failed"
+		if playlistErr, ok := err.(PlaylistError); ok {
+			event = playlistErr.Event
+		}
+		tools.Error("resolve failed", "url", url, "class", event, "took", time.Since(start).Round(time.Millisecond), "error", err)
+		metrics.Resolves.Add(1, event)
+		if event == hooks.DailyLimit {
+			metrics.DailyLimit.Set(1)
+		}
+		tools.Emit(tools.EventResolveEnd, map[string]any{"url": url, "error": err.Error(), "class": event})
+		tools.Emit(tools.EventFailed, map[string]any{"url": url, "error": err.Error(), "class": event})
+		config.notify(hooks.Payload{Event: event, Url: url, Error: err.Error()})
+		return
+	}
+	if playList.IsNil() {
+		metrics.Resolves.Add(1, "skipped")
+	} else {
+		metrics.Resolves.Add(1, "ok")
+		metrics.DailyLimit.Set(0)
+	}
+	tools.Info("resolved", "url", url, "filename", playList.Filename, "quality", playList.Quality, "segments", playList.Len(), "skipped", playList.IsNil(), "took", time.Since(start).Round(time.Millisecond))
+	tools.Emit(tools.EventResolveEnd, map[string]any{
+		"url":      url,
+		"filename": playList.Filename,
+		"segments": playList.Len(),
+		"duration": playList.Duration.Seconds(),
+		"skipped":  playList.IsNil(),
+	})
+	return
+}
+
+// Defines an error resolving a playlist, Event is the hook event of its cause
+type PlaylistError struct {
+	Event string
+	Err   error
+}
+
+func (e PlaylistError) Error() string {
+	return e.Err.Error()
+}
+
+// Runs the hooks and sends the webhooks of the payload's event
+func (config Config) notify(payload hooks.Payload) {
+	hooks.Notify(config.Webhooks, payload)
+	config.Hooks.Run(payload)
+}
+
+// Resolves the playlist of an url, a skipped video returns an empty playlist and no error
+func (config Config) ResolvePlaylist(urlAny any, jsonLoc int) (playList playlist.Playlist, err error) {
 	defer func() {
 		r := recover()
 		if r != nil {
-			fmt.Fprintf(os.Stderr, "urls are in wrong format, error: %v\n", r)
+			err = fmt.Errorf("urls are in wrong format, error: %v", r)
 		}
 	}()
-	var url string
-	switch t := urlAny.(type) {
-	case string:
-		url = t
-	case []any:
-		if len(t) > 0 {
-			url = t[0].(string)
-		} else {
-			panic("no url")
+	url, _, _ := ParseEntry(urlAny)
+	// skip before spending a view if the video is archived or the crawled info already fails a filter
+	if config.archive.Has(recu.VideoId(url)) {
+		fmt.Printf("Skipped: %v is in the archive\n", url)
+		return
+	}
+	if config.skip(config.listingVideo(url), url) {
+		return
+	}
+	playList, status, err := recu.Parse(url, config.header(), jsonLoc)
+	if err == nil && status == "" {
+		playList.Clip = clip(urlAny)
+		playList.ApplyTemplate(config.Filename, config.Directory)
+		playList.Filename = filepath.Join(config.Complete, playList.Filename)
+		if config.archive.Has(filepath.Base(playList.Filename)) {
+			fmt.Printf("Skipped: %v is in the archive\n", playList.Filename)
+			return playlist.Playlist{}, nil
+		}
+		if config.skip(PlaylistVideo(playList), url) {
+			return playlist.Playlist{}, nil
 		}
-	default:
-		panic("url is incorrect type")
 	}
-	playList, status, err := recu.Parse(url, config.Header, jsonLoc)
 	switch status {
 	case "cloudflare":
-		fmt.Fprintf(os.Stderr, "%s\nCloudflare Blocked: Failed on url: %v\n", err.Error(), url)
+		err = PlaylistError{hooks.Cloudflare, fmt.Errorf("%v\nCloudflare Blocked: Failed on url: %v", err, url)}
 	case "cookie":
-		fmt.Fprintf(os.Stderr, "Please Log in: Failed on url: %v\n", url)
+		err = PlaylistError{hooks.Login, fmt.Errorf("Please Log in: Failed on url: %v", url)}
 	case "wait":
-		fmt.Fprintf(os.Stderr, "Daily View Used: Failed on url: %v\n", url)
+		err = PlaylistError{hooks.DailyLimit, fmt.Errorf("Daily View Used: Failed on url: %v", url)}
 	case "panic":
-		fmt.Fprintf(os.Stderr, "Error: %s\nFailed on url: %v\n", err.Error(), url)
+		err = PlaylistError{"failed", fmt.Errorf("Error: %v\nFailed on url: %v", err, url)}
 	}
 	return
 }
 
-// Saves video to working directory
-func (config *Config) GetVideo(playList playlist.Playlist) (fail int) {
-	defer func() {
-		r := recover()
-		if r != nil {
-			fmt.Fprintf(os.Stderr, "urls are in wrong format, error: %v\n", r)
-			fail = 1
-		}
-	}()
-	var url string
-	var duration []float64 = nil
-	var num int = 0
-	// parse list of urls in json
-	switch t := config.Urls[playList.JsonLoc].(type) {
+// Parses an entry of the url list into its url, resume index and start and end in percent
+func ParseEntry(entry any) (url string, num int, duration []float64) {
+	switch t := entry.(type) {
 	case string:
 		url = t
 	case []any:
@@ -86,6 +175,8 @@ func (config *Config) GetVideo(playList playlist.Playlist) (fail int) {
 			url = t[0].(string)
 			duration = tools.PercentPrase(t[1:4])
 			num = int(t[4].(float64))
+		case 0:
+			panic("no url")
 		default:
 			panic("incorrect length of url array")
 		}
@@ -95,40 +186,309 @@ func (config *Config) GetVideo(playList playlist.Playlist) (fail int) {
 	if duration == nil {
 		duration = []float64{0, 100}
 	}
-	// download and mux playlist
-	fail = recu.Mux(playList, tools.FormatedHeader(config.Header, "", 0), num, duration)
-	if fail == 0 {
-		fmt.Printf("Completed: %v:%v\n", playList.Filename, url)
+	return
+}
+
+// Saves video to working directory
+func (config *Config) GetVideo(playList playlist.Playlist) (fail int) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			fmt.Fprintf(os.Stderr, "urls are in wrong format, error: %v\n", r)
+			fail = 1
+		}
+	}()
+	opts := config.Options()
+	fail, _ = config.DownloadVideo(config.Urls[playList.JsonLoc], playList, opts)
+	if fail == 0 || fail == recu.NotStarted {
 		return
 	}
 	// if fail, save state to json
 	fmt.Fprintf(os.Stderr, "Download Failed at line: %v\n", fail)
-	switch t := config.Urls[playList.JsonLoc].(type) {
+	if opts.Target == "-" {
+		return
+	}
+	config.Urls[playList.JsonLoc] = ResumeEntry(config.Urls[playList.JsonLoc], fail)
+	tools.Info("resume point saved", "url", EntryUrl(config.Urls[playList.JsonLoc]), "index", fail)
+	err := config.Save()
+	if err != nil {
+		fmt.Println(err)
+	}
+	return
+}
+
+// Downloads the video of an url entry, returns the index to resume at like recu.Mux.
+// Completed videos are archived and the hooks and webhooks of the outcome are run.
+// A nil entry is a video read from a local playlist, it has no url and its playlist is not saved
+func (config Config) DownloadVideo(entry any, playList playlist.Playlist, opts recu.Options) (fail int, stats recu.Stats) {
+	url, num, duration := "", 0, []float64{0, 100}
+	// parse list of urls in json
+	if entry != nil {
+		url, num, duration = ParseEntry(entry)
+	}
+	// a stream can not be resumed
+	if opts.Target == "-" {
+		num = 0
+	}
+	err := playList.MakeDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can not create directory: %v\n", err)
+		return recu.NotStarted, stats
+	}
+	// download and mux playlist, a header changed by a reload of the json is used by the next segment
+	opts.Header = func() map[string]string {
+		return tools.FormatedHeader(config.header(), "", 0)
+	}
+	fail, stats = recu.Mux(playList, opts.Header(), num, duration, opts)
+	payload := hooks.Payload{
+		Url:      url,
+		Filename: playList.Filename,
+		Path:     stats.Path,
+		Segments: stats.Segments,
+		Bytes:    stats.Bytes,
+		Duration: stats.Duration,
+	}
+	// the output was already on disk and left alone, nothing was downloaded so nothing is run or sent
+	if fail == 0 && stats.Skipped {
+		if stats.Verified {
+			config.archiveVideo(url, playList)
+		}
+		return
+	}
+	if fail == 0 {
+		fmt.Printf("Completed: %v:%v\n", playList.Filename, url)
+		// only a video that was written is archived
+		if stats.Segments > 0 {
+			config.archiveVideo(url, playList)
+		}
+		payload.Event = "completed"
+		tools.Emit(tools.EventCompleted, map[string]any{
+			"url":      url,
+			"filename": playList.Filename,
+			"path":     stats.Path,
+			"segments": stats.Segments,
+			"bytes":    stats.Bytes,
+			"duration": stats.Duration,
+		})
+		config.notify(payload)
+		return
+	}
+	// the playlist of a failed download is kept, so it can be downloaded again with playlist <playlist.m3u8> without using a view
+	if entry != nil {
+		savePlaylist(playList, stats.Path, opts)
+	}
+	payload.Event = "failed"
+	payload.Error = fmt.Sprintf("download failed at segment %d", fail)
+	if stats.Segments > 0 {
+		payload.Event = "partial"
+	}
+	if fail == recu.NotStarted {
+		payload.Error = "download could not be started"
+	}
+	// a cancelled download did not fail
+	if !opts.Control.Cancelled() {
+		class := "download"
+		if fail == recu.NotStarted {
+			class = "not_started"
+		}
+		tools.Emit(tools.EventFailed, map[string]any{"url": url, "filename": playList.Filename, "index": fail, "error": payload.Error, "class": class})
+		config.notify(payload)
+	}
+	return
+}
+
+// Adds the video id of the url and the filename of a completed video to the archive, a video without url only by its filename
+func (config Config) archiveVideo(url string, playList playlist.Playlist) {
+	err := config.archive.Add(recu.VideoId(url), filepath.Base(playList.Filename))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// Returns the entry with its resume index set, the format of the entry is kept
+func ResumeEntry(entry any, fail int) any {
+	switch t := entry.(type) {
 	case string:
-		config.Urls[playList.JsonLoc] = []any{t, fail}
+		return []any{t, fail}
 	case []any:
 		switch len(t) {
 		case 1:
-			t = append(t, fail)
-			config.Urls[playList.JsonLoc] = t
+			return append(t, fail)
 		case 2:
 			t[1] = fail
-			config.Urls[playList.JsonLoc] = t
 		case 4:
-			t = append(t, fail)
-			config.Urls[playList.JsonLoc] = t
+			return append(t, fail)
 		case 5:
 			t[4] = fail
-			config.Urls[playList.JsonLoc] = t
 		}
 	}
-	err := config.Save()
+	return entry
+}
+
+// Saves the playlist of a failed video next to its output, nothing is saved when streaming to stdout
+func savePlaylist(playList playlist.Playlist, path string, opts recu.Options) {
+	if path == "-" || opts.Target == "-" {
+		return
+	}
+	if path == "" {
+		path = playList.Filename
+	}
+	err := os.WriteFile(strings.TrimSuffix(path, ".ts")+".m3u8", playList.M3u8, 0666)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println(playList.M3u8)
+		fmt.Fprintf(os.Stderr, "Failed to write playlist data: %v\n", err)
+	}
+}
+
+// Returns the time range of an url array with timestamps, e.g. 55.00-1.10.00
+func clip(urlAny any) string {
+	t, ok := urlAny.([]any)
+	if !ok || len(t) < 4 {
+		return ""
+	}
+	start, _ := t[1].(string)
+	end, _ := t[2].(string)
+	return strings.ReplaceAll(start+"-"+end, ":", ".")
+}
+
+// Parses the filters of the json and of the --filter options
+func (config *Config) LoadFilters() (err error) {
+	config.filters, err = filter.ParseAll(append(append([]string{}, config.Filter...), tools.Options("filter")...))
+	return
+}
+
+// Returns how Mux should write its output
+func (config Config) Options() (opts recu.Options) {
+	opts.Existing = config.Existing
+	if existing, ok := tools.Option("existing"); ok {
+		opts.Existing = existing
+	}
+	opts.Store = config.Store
+	if store, ok := tools.Option("store"); ok {
+		opts.Store = store
+	}
+	opts.Target, _ = tools.Option("target")
+	opts.Incomplete = config.Incomplete
+	opts.Complete = config.Complete
+	opts.Part = config.Part
+	minFree := config.MinFree
+	if option, ok := tools.Option("min-free"); ok {
+		minFree = option
+	}
+	opts.MinFree = 1e9
+	if minFree != "" {
+		opts.MinFree, _ = tools.ParseBytes(minFree)
+	}
+	return
+}
+
+// Checks the values of the json that are not checked while downloading
+func (config Config) Validate() error {
+	switch config.Options().Existing {
+	case "", "skip", "overwrite", "verify", "rename":
+	default:
+		return fmt.Errorf("existing must be skip, overwrite, verify or rename")
+	}
+	switch config.Options().Store {
+	case "", "file", "segments":
+	default:
+		return fmt.Errorf("store must be file or segments")
+	}
+	err := config.Hooks.Validate()
+	if err != nil {
+		return err
+	}
+	err = hooks.ValidateWebhooks(config.Webhooks)
+	if err != nil {
+		return err
+	}
+	if config.MinFree != "" {
+		_, err := tools.ParseBytes(config.MinFree)
+		if err != nil {
+			return fmt.Errorf("min_free: %v", err)
+		}
+	}
+	if minFree, ok := tools.Option("min-free"); ok {
+		_, err := tools.ParseBytes(minFree)
+		if err != nil {
+			return fmt.Errorf("--min-free: %v", err)
+		}
+	}
+	for _, template := range []string{config.Filename, config.Directory} {
+		err := playlist.CheckTemplate(template)
+		if err != nil {
+			return err
+		}
+	}
+	switch config.Options().Target {
+	case "", "-":
+	default:
+		return fmt.Errorf("target must be - to write to stdout")
+	}
+	return nil
+}
+
+// Opens the archive given by --archive or the json, no archive is used if neither is set
+func (config *Config) OpenArchive() (err error) {
+	path, ok := tools.Option("archive")
+	if !ok {
+		path = config.Archive
+	}
+	if path == "" {
+		return
+	}
+	config.archive, err = LoadArchive(path)
+	return
+}
+
+// Rebuilds the archive from the completed .ts files in the working directory
+func (config *Config) RebuildArchive() (added int, err error) {
+	if config.archive == nil {
+		config.archive, err = LoadArchive("archive.txt")
+		if err != nil {
+			return
+		}
+	}
+	return config.archive.Rebuild(".")
+}
+
+// Returns whether the video should be skipped, printing the failed filter
+func (config Config) skip(video filter.Video, url string) bool {
+	ok, failed := filter.MatchAll(config.filters, video)
+	if !ok {
+		fmt.Printf("Skipped: %v does not match filter %v\n", url, failed)
+	}
+	return !ok
+}
+
+// Returns what the crawled info knows about a video url
+func (config Config) listingVideo(url string) (video filter.Video) {
+	listing, ok := config.Info[recu.VideoId(url)]
+	if !ok {
+		return
+	}
+	video.Performer = listing.Performer
+	video.Date, _ = time.Parse("2006-01-02", listing.Date)
+	if listing.Duration != "" {
+		video.Duration, _ = filter.ParseDuration(listing.Duration)
 	}
 	return
 }
 
+// Returns what the playlist knows about a video
+func PlaylistVideo(playList playlist.Playlist) filter.Video {
+	return filter.Video{
+		Performer: playList.Performer,
+		Date:      playList.Date,
+		Duration:  playList.Duration,
+	}
+}
+
+// Returns whether the playlist passes the filters
+func (config Config) Matches(playList playlist.Playlist) bool {
+	return !config.skip(PlaylistVideo(playList), playList.Filename)
+}
+
 // Returns default templet
 func Default() Config {
 	var jsonTemplet Config
@@ -140,30 +500,46 @@ func Default() Config {
 	return jsonTemplet
 }
 
-// Saves Json
+// Returns the location of the json
+func Location() string {
+	if tools.Argparser(1) != "" {
+		return tools.Argparser(1)
+	}
+	return "config.json"
+}
+
+// Saves Json, edits made to the file since it was read are kept and only the values changed since are written over them
 func (config *Config) Save() (err error) {
 	mtx.Lock()
-	var jsonData []byte
-	jsonData, err = json.MarshalIndent(struct {
-		Urls   []any             `json:"urls"`
-		Header map[string]string `json:"header"`
-	}{
-		Urls:   config.Urls,
-		Header: config.Header,
-	}, "", "\t")
+	defer mtx.Unlock()
+	ours, err := json.Marshal(config)
 	if err != nil {
 		return fmt.Errorf("error: Parsing Json%v", err)
 	}
-	jsonLocation := "config.json"
-	if tools.Argparser(1) != "" {
-		jsonLocation = tools.Argparser(1)
+	jsonData := ours
+	jsonLocation := Location()
+	if config.base != nil {
+		disk, err := os.ReadFile(jsonLocation)
+		if err == nil {
+			jsonData, err = merge(config.base.data, ours, disk)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error: Saving Json: %v was edited and can not be read, not saved: %v", jsonLocation, err)
+		}
 	}
-	err = os.WriteFile(jsonLocation, jsonData, 0666)
+	var indented bytes.Buffer
+	err = json.Indent(&indented, jsonData, "", "\t")
 	if err != nil {
-		err = fmt.Errorf("error: Saving Json:%v", err)
-		return
+		return fmt.Errorf("error: Parsing Json%v", err)
+	}
+	err = os.WriteFile(jsonLocation, indented.Bytes(), 0666)
+	if err != nil {
+		return fmt.Errorf("error: Saving Json:%v", err)
+	}
+	// what was written is the base of the next save, so values edited in the file since are not changes of ours
+	if config.base != nil {
+		config.base.data = ours
 	}
-	mtx.Unlock()
 	return
 }
 
@@ -172,31 +548,57 @@ func (config *Config) Empty() bool {
 
 }
 
-// Parse Urls from HTML
-//func (config Config) ParseHtml(url string) (err error) {
-//	fmt.Println("Downloading HTML")
-//	resp, code, err := request(url, 10, formatedHeader(config.Header, "", 1), nil, "GET")
-//	if code != 200 || err != nil {
-//		if err == nil {
-//			err = fmt.Errorf("response: %s, status code: %d, cloudflare blocked", ANSIColor(string(resp), 2), code)
-//		}
-//		return
-//	}
-//	fmt.Println("Searching for Links")
-//	urlSplit := strings.Split(url, "/")
-//	name := urlSplit[4]
-//	prefix := strings.Join(urlSplit[:3], "/") + fmt.Sprintf("/%s/video/", name)
-//	suffix := "/play"
-//	var urls []any
-//	lines := strings.Split(string(resp), "\n")
-//	for _, v := range lines {
-//		code, err := searchString(v, fmt.Sprintf(`href="/%s/video/`, name), `/play"`)
-//		if err != nil {
-//			continue
-//		}
-//		urls = append(urls, prefix+code+suffix)
-//	}
-//	config.Urls = urls
-//	err = SaveJson(config)
-//	return
-//}
+// Adds crawled videos to the url list, skipping videos already in it, returns the json locations added
+func (config *Config) AddListings(listings []recu.Listing) (added []int) {
+	existing := make(map[string]bool)
+	for _, entry := range config.Urls {
+		existing[recu.VideoId(EntryUrl(entry))] = true
+	}
+	// drop the placeholder of the default templet
+	if len(config.Urls) == 1 && EntryUrl(config.Urls[0]) == "" {
+		config.Urls = config.Urls[:0]
+	}
+	for _, listing := range listings {
+		if existing[listing.Id] {
+			continue
+		}
+		existing[listing.Id] = true
+		config.Urls = append(config.Urls, listing.Url)
+		if config.Info == nil {
+			config.Info = make(map[string]recu.Listing)
+		}
+		config.Info[listing.Id] = listing
+		added = append(added, len(config.Urls)-1)
+	}
+	return
+}
+
+// Returns the json locations of the videos with the ids, the first entry of each video
+func (config Config) Locations(ids []string) (locs []int) {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	for i, entry := range config.Urls {
+		id := recu.VideoId(EntryUrl(entry))
+		if wanted[id] {
+			locs = append(locs, i)
+			delete(wanted, id)
+		}
+	}
+	return
+}
+
+// Returns the url of an entry in the url list, empty if it is in the wrong format
+func EntryUrl(entry any) string {
+	switch t := entry.(type) {
+	case string:
+		return t
+	case []any:
+		if len(t) > 0 {
+			url, _ := t[0].(string)
+			return url
+		}
+	}
+	return ""
+}
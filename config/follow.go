@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Returns the path of a file kept next to the json
+func Path(name string) string {
+	return filepath.Join(filepath.Dir(Location()), name)
+}
+
+// Defines the persistent store of video ids already seen on followed performer pages
+type Seen struct {
+	path string
+	ids  map[string][]string
+	mtx  sync.Mutex
+}
+
+// Loads the seen store kept next to the json, a missing store is empty
+func LoadSeen() (seen *Seen, err error) {
+	seen = &Seen{
+		path: Path("seen.json"),
+		ids:  make(map[string][]string),
+	}
+	data, err := os.ReadFile(seen.path)
+	if os.IsNotExist(err) {
+		return seen, nil
+	} else if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &seen.ids)
+	if err != nil {
+		err = fmt.Errorf("error: Reading %v: %v", seen.path, err)
+	}
+	return
+}
+
+// Returns whether the performer has been polled before
+func (seen *Seen) Known(performer string) bool {
+	seen.mtx.Lock()
+	defer seen.mtx.Unlock()
+	_, ok := seen.ids[performer]
+	return ok
+}
+
+// Returns whether the video id was seen on the performer page
+func (seen *Seen) Has(performer, id string) bool {
+	seen.mtx.Lock()
+	defer seen.mtx.Unlock()
+	for _, v := range seen.ids[performer] {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Adds the video ids to the performer and saves the store
+func (seen *Seen) Add(performer string, ids ...string) error {
+	seen.mtx.Lock()
+	defer seen.mtx.Unlock()
+	if seen.ids[performer] == nil {
+		seen.ids[performer] = make([]string, 0, len(ids))
+	}
+	seen.ids[performer] = append(seen.ids[performer], ids...)
+	data, err := json.MarshalIndent(seen.ids, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(seen.path, data, 0666)
+	if err != nil {
+		return fmt.Errorf("error: Saving %v: %v", seen.path, err)
+	}
+	return nil
+}
+
+// Returns the time to wait until the next poll, the interval with ±10% jitter
+func (config Config) NextPoll() time.Duration {
+	interval, err := time.ParseDuration(config.FollowInterval)
+	if err != nil || interval <= 0 {
+		interval = time.Hour
+	}
+	jitter := (rand.Float64()*0.2 - 0.1) * float64(interval)
+	return interval + time.Duration(jitter)
+}
@@ -0,0 +1,281 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// receives once the json should be read again
+	reloads   = make(chan struct{}, 1)
+	watchOnce sync.Once
+	// header of the json as it was last reloaded or changed, requests use it over the header of the json they were started with
+	live struct {
+		mtx    sync.Mutex
+		header map[string]string
+	}
+)
+
+// Reads the json at its location
+func Load() (config Config, err error) {
+	data, err := os.ReadFile(Location())
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return
+	}
+	// what Save compares against to find the values changed since
+	config.base = &snapshot{}
+	config.base.data, err = json.Marshal(config)
+	return
+}
+
+// Asks for the json to be read again, e.g. on SIGHUP
+func RequestReload() {
+	select {
+	case reloads <- struct{}{}:
+	default:
+	}
+}
+
+// Returns a channel that receives when the json changes on disk or a reload is requested, the file is checked every second
+func Watch() <-chan struct{} {
+	watchOnce.Do(func() {
+		go watch(Location())
+	})
+	return reloads
+}
+
+func watch(path string) {
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	for {
+		time.Sleep(time.Second)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(modTime) || info.Size() != size {
+			modTime, size = info.ModTime(), info.Size()
+			RequestReload()
+		}
+	}
+}
+
+// Reads the json again, checks it and loads its filters. From now on every request uses its header,
+// including the requests of downloads started before
+func (config Config) Reload() (reloaded Config, err error) {
+	reloaded, err = Load()
+	if err != nil {
+		return
+	}
+	err = reloaded.Validate()
+	if err != nil {
+		return
+	}
+	err = reloaded.LoadFilters()
+	if err != nil {
+		return
+	}
+	// the archive stays open
+	reloaded.archive = config.archive
+	SetHeader(reloaded.Header)
+	return
+}
+
+// Makes every request from now on use the header, including the requests of downloads started before
+func SetHeader(header map[string]string) {
+	live.mtx.Lock()
+	defer live.mtx.Unlock()
+	live.header = header
+}
+
+// Returns the json locations of the urls in reloaded that are not in config, and the urls of config no longer in reloaded
+func (config Config) Changes(reloaded Config) (added []int, removed map[string]bool) {
+	old := make(map[string]bool, len(config.Urls))
+	for _, entry := range config.Urls {
+		old[EntryUrl(entry)] = true
+	}
+	removed = make(map[string]bool)
+	for url := range old {
+		removed[url] = true
+	}
+	for i, entry := range reloaded.Urls {
+		url := EntryUrl(entry)
+		delete(removed, url)
+		if url != "" && !old[url] {
+			added = append(added, i)
+		}
+	}
+	return
+}
+
+// Returns the header of the json as it was last reloaded or changed, or its own if it never was
+func (config Config) header() map[string]string {
+	live.mtx.Lock()
+	defer live.mtx.Unlock()
+	if live.header != nil {
+		return live.header
+	}
+	return config.Header
+}
+
+// Merges the values changed from base to ours into disk, values changed in both are taken from ours.
+// Urls are matched by their url and the header and info by their keys, so urls and headers
+// edited in the file are kept when another url or header is changed
+func merge(base, ours, disk []byte) ([]byte, error) {
+	if !json.Valid(disk) {
+		return nil, fmt.Errorf("invalid json")
+	}
+	merged := mergeObject(base, ours, disk, func(key string, base, ours, disk json.RawMessage) json.RawMessage {
+		switch key {
+		case "urls":
+			return mergeUrls(base, ours, disk)
+		case "header", "info":
+			return mergeObject(base, ours, disk, nil)
+		}
+		return mergeValue(base, ours, disk)
+	})
+	var compacted bytes.Buffer
+	err := json.Compact(&compacted, merged)
+	return compacted.Bytes(), err
+}
+
+// Returns disk if ours did not change from base, otherwise ours, nil is a missing value
+func mergeValue(base, ours, disk json.RawMessage) json.RawMessage {
+	if bytes.Equal(base, ours) {
+		return disk
+	}
+	return ours
+}
+
+// Merges every key of the objects with mergeValue, or with value if it is not nil, keeping the order of disk
+func mergeObject(base, ours, disk json.RawMessage, value func(key string, base, ours, disk json.RawMessage) json.RawMessage) json.RawMessage {
+	if bytes.Equal(base, ours) {
+		return disk
+	}
+	if value == nil {
+		value = func(key string, base, ours, disk json.RawMessage) json.RawMessage {
+			return mergeValue(base, ours, disk)
+		}
+	}
+	_, baseValues := parseObject(base)
+	oursKeys, oursValues := parseObject(ours)
+	diskKeys, diskValues := parseObject(disk)
+	var b bytes.Buffer
+	write := func(key string) {
+		merged := value(key, baseValues[key], oursValues[key], diskValues[key])
+		if merged == nil {
+			return
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(merged)
+	}
+	for _, key := range diskKeys {
+		write(key)
+	}
+	for _, key := range oursKeys {
+		if _, ok := diskValues[key]; !ok {
+			write(key)
+		}
+	}
+	return json.RawMessage("{" + b.String() + "}")
+}
+
+// Returns the keys of a json object in their order and their values, nothing if it is not an object
+func parseObject(data json.RawMessage) (keys []string, values map[string]json.RawMessage) {
+	values = make(map[string]json.RawMessage)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('{') {
+		return nil, values
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return
+		}
+		key, _ := token.(string)
+		var value json.RawMessage
+		err = decoder.Decode(&value)
+		if err != nil {
+			return
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	return
+}
+
+// Merges the url entries matched by their url: entries changed in ours replace the entry in disk,
+// entries added in ours are appended and entries removed in ours are removed
+func mergeUrls(base, ours, disk json.RawMessage) json.RawMessage {
+	if bytes.Equal(base, ours) {
+		return disk
+	}
+	var baseEntries, oursEntries, diskEntries []json.RawMessage
+	json.Unmarshal(base, &baseEntries)
+	json.Unmarshal(ours, &oursEntries)
+	json.Unmarshal(disk, &diskEntries)
+	baseByUrl := entriesByUrl(baseEntries)
+	oursByUrl := entriesByUrl(oursEntries)
+	diskByUrl := entriesByUrl(diskEntries)
+	merged := make([]json.RawMessage, 0, len(diskEntries))
+	for _, entry := range diskEntries {
+		url := rawEntryUrl(entry)
+		baseEntry, inBase := baseByUrl[url]
+		oursEntry, inOurs := oursByUrl[url]
+		switch {
+		case inBase && !inOurs:
+			continue
+		case inBase && !bytes.Equal(baseEntry, oursEntry):
+			entry = oursEntry
+		}
+		merged = append(merged, entry)
+	}
+	for _, entry := range oursEntries {
+		url := rawEntryUrl(entry)
+		_, inBase := baseByUrl[url]
+		_, inDisk := diskByUrl[url]
+		if !inBase && !inDisk {
+			merged = append(merged, entry)
+			diskByUrl[url] = entry
+		}
+	}
+	data, _ := json.Marshal(merged)
+	return data
+}
+
+// Returns the first entry of every url
+func entriesByUrl(entries []json.RawMessage) map[string]json.RawMessage {
+	byUrl := make(map[string]json.RawMessage, len(entries))
+	for _, entry := range entries {
+		url := rawEntryUrl(entry)
+		if _, ok := byUrl[url]; !ok {
+			byUrl[url] = entry
+		}
+	}
+	return byUrl
+}
+
+func rawEntryUrl(entry json.RawMessage) string {
+	var value any
+	json.Unmarshal(entry, &value)
+	return EntryUrl(value)
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		ours string
+		disk string
+		want string
+	}{
+		{
+			name: "nothing changed keeps disk",
+			base: `{"urls":["a"],"header":{"Cookie":"x"}}`,
+			ours: `{"urls":["a"],"header":{"Cookie":"x"}}`,
+			disk: `{"urls":["a","b"],"header":{"Cookie":"y"},"extra":1}`,
+			want: `{"urls":["a","b"],"header":{"Cookie":"y"},"extra":1}`,
+		},
+		{
+			name: "changed url keeps urls and header edited on disk",
+			base: `{"urls":["a",["b",1]],"header":{"Cookie":"x","User-Agent":"u"}}`,
+			ours: `{"urls":["a",["b",2]],"header":{"Cookie":"x","User-Agent":"u"}}`,
+			disk: `{"urls":["c","a",["b",1]],"header":{"Cookie":"y","User-Agent":"u"}}`,
+			want: `{"urls":["c","a",["b",2]],"header":{"Cookie":"y","User-Agent":"u"}}`,
+		},
+		{
+			name: "changed header key keeps other keys edited on disk",
+			base: `{"urls":["a"],"header":{"Cookie":"x","User-Agent":"u"}}`,
+			ours: `{"urls":["a"],"header":{"Cookie":"z","User-Agent":"u"}}`,
+			disk: `{"urls":["a"],"header":{"Cookie":"x","User-Agent":"v"}}`,
+			want: `{"urls":["a"],"header":{"Cookie":"z","User-Agent":"v"}}`,
+		},
+		{
+			name: "value changed on both sides is taken from ours",
+			base: `{"urls":["a"],"existing":"skip"}`,
+			ours: `{"urls":["a"],"existing":"verify"}`,
+			disk: `{"urls":["a"],"existing":"rename"}`,
+			want: `{"urls":["a"],"existing":"verify"}`,
+		},
+		{
+			name: "value removed by ours is removed",
+			base: `{"urls":["a"],"store":"segments"}`,
+			ours: `{"urls":["a"]}`,
+			disk: `{"urls":["a"],"store":"segments"}`,
+			want: `{"urls":["a"]}`,
+		},
+		{
+			name: "value added by ours is appended",
+			base: `{"urls":["a"]}`,
+			ours: `{"urls":["a"],"archive":"archive.txt"}`,
+			disk: `{"urls":["a"],"extra":true}`,
+			want: `{"urls":["a"],"extra":true,"archive":"archive.txt"}`,
+		},
+		{
+			name: "order and formatting of disk are kept",
+			base: `{"header":{"Cookie":"x"},"urls":["a"]}`,
+			ours: `{"header":{"Cookie":"x"},"urls":[["a",3]]}`,
+			disk: "{\n\t\"urls\": [\n\t\t\"a\"\n\t],\n\t\"header\": {\"Cookie\": \"x\"}\n}",
+			want: `{"urls":[["a",3]],"header":{"Cookie":"x"}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := merge([]byte(test.base), []byte(test.ours), []byte(test.disk))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeInvalidDisk(t *testing.T) {
+	_, err := merge([]byte(`{"urls":["a"]}`), []byte(`{"urls":["b"]}`), []byte(`{"urls":[`))
+	if err == nil {
+		t.Error("merged into a half written json")
+	}
+}
+
+func TestMergeUrls(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		ours string
+		disk string
+		want string
+	}{
+		{
+			name: "unchanged keeps disk",
+			base: `["a","b"]`,
+			ours: `["a","b"]`,
+			disk: `["b","c"]`,
+			want: `["b","c"]`,
+		},
+		{
+			name: "changed entry replaces the entry with its url",
+			base: `["a","b"]`,
+			ours: `["a",["b",10]]`,
+			disk: `["c","b","a"]`,
+			want: `["c",["b",10],"a"]`,
+		},
+		{
+			name: "entry removed on disk stays removed",
+			base: `["a","b"]`,
+			ours: `["a",["b",10]]`,
+			disk: `["a"]`,
+			want: `["a"]`,
+		},
+		{
+			name: "entry added by ours is appended",
+			base: `["a"]`,
+			ours: `["a","n"]`,
+			disk: `["a","c"]`,
+			want: `["a","c","n"]`,
+		},
+		{
+			name: "entry added on both sides is kept once",
+			base: `["a"]`,
+			ours: `["a","n"]`,
+			disk: `["a",["n",5]]`,
+			want: `["a",["n",5]]`,
+		},
+		{
+			name: "entry removed by ours is removed",
+			base: `["a","b"]`,
+			ours: `["a"]`,
+			disk: `["a","b","c"]`,
+			want: `["a","c"]`,
+		},
+		{
+			name: "entry edited on disk is kept when another changes",
+			base: `["a","b"]`,
+			ours: `["a",["b",4]]`,
+			disk: `[["a","0:10","0:20","1:00"],"b"]`,
+			want: `[["a","0:10","0:20","1:00"],["b",4]]`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := mergeUrls([]byte(test.base), []byte(test.ours), []byte(test.disk))
+			if string(got) != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+// Edits made to an entry after this process saved it must survive its next save
+func TestSaveKeepsEditsAfterSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	args := os.Args
+	os.Args = []string{"recurbate", path}
+	defer func() { os.Args = args }()
+	write := func(data string) {
+		err := os.WriteFile(path, []byte(data), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"urls":["url1","url2"],"header":{"Cookie":"x"}}`)
+	config, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a copy holds the same base, like the copies the downloads run with
+	other := config
+	config.Urls[0] = ResumeEntry(config.Urls[0], 10)
+	err = config.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(`{"urls":[["url1","0:10","0:20","1:00",10],"url2"],"header":{"Cookie":"y"}}`)
+	other.Urls[1] = ResumeEntry(other.Urls[1], 20)
+	err = other.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Urls   []any             `json:"urls"`
+		Header map[string]string `json:"header"`
+	}
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(saved.Urls)
+	want := `[["url1","0:10","0:20","1:00",10],["url2",20]]`
+	if string(got) != want {
+		t.Errorf("urls: got %s, want %s", got, want)
+	}
+	if saved.Header["Cookie"] != "y" {
+		t.Errorf("cookie edited on disk was overwritten: %v", saved.Header["Cookie"])
+	}
+}
@@ -0,0 +1,236 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"recurbate/metrics"
+	"recurbate/tools"
+	"strconv"
+	"strings"
+)
+
+// Defines the body of a request adding a job, start, end and length are timestamps like 1:10:00
+type addRequest struct {
+	Url      string `json:"url"`
+	Start    string `json:"start,omitempty"`
+	End      string `json:"end,omitempty"`
+	Length   string `json:"length,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+// Returns the url entry of the request
+func (req addRequest) entry() (any, error) {
+	if req.Url == "" {
+		return nil, fmt.Errorf("url is missing")
+	}
+	if req.Start == "" && req.End == "" && req.Length == "" {
+		return req.Url, nil
+	}
+	if req.Start == "" || req.End == "" || req.Length == "" {
+		return nil, fmt.Errorf("start, end and length are needed to download part of a video")
+	}
+	return []any{req.Url, req.Start, req.End, req.Length}, nil
+}
+
+// Returns the handler of the REST API
+func (daemon *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/jobs", daemon.handleJobs)
+	mux.HandleFunc("/api/jobs/", daemon.handleJob)
+	mux.HandleFunc("/metrics", metrics.Handler)
+	if daemon.Dashboard {
+		mux.HandleFunc("/api/header", daemon.handleHeader)
+		mux.HandleFunc("/", daemon.handleDashboard)
+	}
+	return mux
+}
+
+// Serves the REST API until aborted, the scheduler runs alongside it
+func (daemon *Daemon) Run(addr string) error {
+	server := &http.Server{Addr: addr, Handler: guard(addr, daemon.Handler())}
+	defer tools.StartProgress()()
+	done := make(chan struct{})
+	go func() {
+		daemon.Schedule()
+		server.Close()
+		close(done)
+	}()
+	fmt.Printf("Daemon listening on http://%v/ with the %v policy\n", addr, daemon.policy)
+	if daemon.Dashboard {
+		fmt.Printf("Dashboard on http://%v/\n", addr)
+	}
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		return err
+	}
+	<-done
+	return nil
+}
+
+// Rejects requests that a page of another site could send: the Host must name the address listened on,
+// so a page rebound to it by DNS can not read the API, and changes must be sent as JSON, which a form can not post
+func guard(addr string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(addr, r.Host) {
+			replyError(w, http.StatusForbidden, fmt.Errorf("host %q does not match the address %v", r.Host, addr))
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				replyError(w, http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type must be application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns whether the Host header names the address listened on. On a loopback address localhost and
+// the loopback IPs are accepted, on every interface any IP and the name of the machine
+func allowedHost(addr string, host string) bool {
+	listenHost, listenPort, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	reqHost, reqPort, err := net.SplitHostPort(host)
+	if err != nil {
+		reqHost, reqPort = host, "80"
+	}
+	if reqPort != listenPort {
+		return false
+	}
+	if strings.EqualFold(reqHost, listenHost) {
+		return true
+	}
+	loopback := strings.EqualFold(reqHost, "localhost") || isLoopback(reqHost)
+	switch {
+	case listenHost == "" || listenHost == "0.0.0.0" || listenHost == "::":
+		name, _ := os.Hostname()
+		return loopback || net.ParseIP(reqHost) != nil || (name != "" && strings.EqualFold(reqHost, name))
+	case strings.EqualFold(listenHost, "localhost") || isLoopback(listenHost):
+		return loopback
+	}
+	return false
+}
+
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// GET lists the jobs, POST adds one
+func (daemon *Daemon) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		reply(w, http.StatusOK, daemon.Jobs())
+	case http.MethodPost:
+		var req addRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			replyError(w, http.StatusBadRequest, err)
+			return
+		}
+		entry, err := req.entry()
+		if err != nil {
+			replyError(w, http.StatusBadRequest, err)
+			return
+		}
+		job, err := daemon.Add(entry, req.Priority)
+		if err != nil {
+			replyError(w, http.StatusBadRequest, err)
+			return
+		}
+		reply(w, http.StatusCreated, job)
+	default:
+		replyError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+	}
+}
+
+// Serves /api/jobs/<id> and /api/jobs/<id>/<action>
+func (daemon *Daemon) handleJob(w http.ResponseWriter, r *http.Request) {
+	split := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/"), "/")
+	id, err := strconv.Atoi(split[0])
+	if err != nil || len(split) > 2 {
+		replyError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	if len(split) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			job, err := daemon.Job(id)
+			if err != nil {
+				replyError(w, http.StatusNotFound, err)
+				return
+			}
+			reply(w, http.StatusOK, job)
+		case http.MethodDelete:
+			daemon.respond(w, id, daemon.Remove(id))
+		default:
+			replyError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		replyError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	switch split[1] {
+	case "pause":
+		err = daemon.Pause(id)
+	case "resume":
+		err = daemon.Resume(id)
+	case "cancel":
+		err = daemon.Cancel(id)
+	case "retry":
+		err = daemon.Retry(id)
+	case "priority":
+		var req struct {
+			Priority int `json:"priority"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			replyError(w, http.StatusBadRequest, err)
+			return
+		}
+		err = daemon.Prioritize(id, req.Priority)
+	default:
+		replyError(w, http.StatusNotFound, fmt.Errorf("unknown action: %v", split[1]))
+		return
+	}
+	daemon.respond(w, id, err)
+}
+
+// Replies with the job after an action, or the error of the action
+func (daemon *Daemon) respond(w http.ResponseWriter, id int, err error) {
+	switch {
+	case err == errNotFound:
+		replyError(w, http.StatusNotFound, err)
+	case err != nil:
+		replyError(w, http.StatusConflict, err)
+	default:
+		job, err := daemon.Job(id)
+		if err != nil {
+			// removed
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		reply(w, http.StatusOK, job)
+	}
+}
+
+// Writes the value as JSON
+func reply(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// Writes the error as JSON
+func replyError(w http.ResponseWriter, status int, err error) {
+	reply(w, status, map[string]string{"error": tools.ShortenString(err.Error(), 500)})
+}
@@ -0,0 +1,430 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"recurbate/config"
+	"recurbate/recu"
+	"recurbate/tools"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job states
+const (
+	Queued    = "queued"
+	Running   = "running"
+	Paused    = "paused"
+	Completed = "completed"
+	Skipped   = "skipped"
+	Failed    = "failed"
+	Cancelled = "cancelled"
+)
+
+// Defines a video in the queue
+type Job struct {
+	Id int `json:"id"`
+	// url entry in the format of the json urls, its resume index is updated when the job stops
+	Entry    any           `json:"entry"`
+	Priority int           `json:"priority"`
+	Status   string        `json:"status"`
+	Filename string        `json:"filename,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Added    time.Time     `json:"added"`
+	Progress recu.Progress `json:"progress"`
+	// set while the job is running
+	control *recu.Control
+}
+
+// Defines the download daemon, jobs are started by priority according to the policy
+type Daemon struct {
+	cfg *config.Config
+	// file the queue is persisted to
+	path string
+	// parallel, series or hybrid, hybrid downloads one video per CDN server at a time
+	policy string
+	// jobs running at once, unlimited if 0
+	limit int
+	// serves the web dashboard along with the API
+	Dashboard bool
+	mtx       sync.Mutex
+	jobs      []*Job
+	nextId    int
+	running   int
+	servers   map[string]*sync.Mutex
+	wake      chan struct{}
+}
+
+// Returns a daemon with the queue loaded from path, a missing queue is empty
+func New(cfg *config.Config, path string, policy string, limit int) (daemon *Daemon, err error) {
+	switch policy {
+	case "", "parallel":
+		policy = "parallel"
+	case "series":
+		limit = 1
+	case "hybrid":
+	default:
+		return nil, fmt.Errorf("unknown policy: %v", policy)
+	}
+	daemon = &Daemon{
+		cfg:     cfg,
+		path:    path,
+		policy:  policy,
+		limit:   limit,
+		nextId:  1,
+		servers: make(map[string]*sync.Mutex),
+		wake:    make(chan struct{}, 1),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return daemon, nil
+	} else if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &daemon.jobs)
+	if err != nil {
+		return nil, fmt.Errorf("error: Reading %v: %v", path, err)
+	}
+	for _, job := range daemon.jobs {
+		// jobs interrupted by a restart resume from their saved index
+		if job.Status == Running {
+			job.Status = Queued
+		}
+		if job.Id >= daemon.nextId {
+			daemon.nextId = job.Id + 1
+		}
+	}
+	return
+}
+
+// Starts queued jobs until aborted
+func (daemon *Daemon) Schedule() {
+	for !tools.Abort {
+		daemon.mtx.Lock()
+		for daemon.limit <= 0 || daemon.running < daemon.limit {
+			job := daemon.next()
+			if job == nil {
+				break
+			}
+			job.Status = Running
+			job.Error = ""
+			job.control = &recu.Control{}
+			daemon.running++
+			go daemon.run(job)
+		}
+		daemon.mtx.Unlock()
+		select {
+		case <-daemon.wake:
+		case <-time.After(time.Second):
+		}
+	}
+	// let running jobs save their resume index
+	for daemon.Running() > 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Returns the number of running jobs
+func (daemon *Daemon) Running() int {
+	daemon.mtx.Lock()
+	defer daemon.mtx.Unlock()
+	return daemon.running
+}
+
+// Returns the queued job with the highest priority, the oldest first, must hold mtx
+func (daemon *Daemon) next() (job *Job) {
+	for _, j := range daemon.jobs {
+		if j.Status != Queued {
+			continue
+		}
+		if job == nil || j.Priority > job.Priority {
+			job = j
+		}
+	}
+	return
+}
+
+// Wakes the scheduler
+func (daemon *Daemon) poke() {
+	select {
+	case daemon.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Downloads a job and records its outcome
+func (daemon *Daemon) run(job *Job) {
+	daemon.mtx.Lock()
+	entry, control := job.Entry, job.control
+	daemon.mtx.Unlock()
+	status, errMsg, fail := daemon.download(entry, control, job)
+	daemon.mtx.Lock()
+	defer daemon.mtx.Unlock()
+	if fail > 0 {
+		// copies of the job may still share the old entry
+		if t, ok := job.Entry.([]any); ok {
+			job.Entry = append([]any{}, t...)
+		}
+		job.Entry = config.ResumeEntry(job.Entry, fail)
+	}
+	// aborted jobs are queued again for the next start
+	if tools.Abort && status == Failed {
+		status = Queued
+	}
+	job.Status = status
+	job.Error = errMsg
+	job.control = nil
+	daemon.running--
+	err := daemon.save()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	daemon.poke()
+}
+
+// Resolves and downloads the entry, returns the status of the job and the index to resume at
+func (daemon *Daemon) download(entry any, control *recu.Control, job *Job) (status string, errMsg string, fail int) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			status, errMsg = Failed, fmt.Sprintf("urls are in wrong format, error: %v", r)
+		}
+	}()
+	// the header may be changed while the job runs
+	daemon.mtx.Lock()
+	cfg := *daemon.cfg
+	daemon.mtx.Unlock()
+	playList, err := cfg.FetchPlaylist(entry, 0)
+	if err != nil {
+		return Failed, err.Error(), 0
+	}
+	if playList.IsNil() {
+		return Skipped, "", 0
+	}
+	daemon.mtx.Lock()
+	job.Filename = playList.Filename
+	daemon.mtx.Unlock()
+	if daemon.policy == "hybrid" {
+		server, err := playList.PlaylistOrigin()
+		if err != nil {
+			return Failed, err.Error(), 0
+		}
+		lock := daemon.server(server)
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	if control.Cancelled() {
+		return Cancelled, "", 0
+	}
+	opts := cfg.Options()
+	opts.Control = control
+	opts.Progress = func(progress recu.Progress) {
+		daemon.mtx.Lock()
+		job.Progress = progress
+		daemon.mtx.Unlock()
+	}
+	fail, _ = cfg.DownloadVideo(entry, playList, opts)
+	switch {
+	case control.Cancelled():
+		return Cancelled, "", fail
+	case fail == 0:
+		return Completed, "", 0
+	case fail == recu.NotStarted:
+		return Failed, "download could not be started", fail
+	}
+	return Failed, fmt.Sprintf("download failed at segment %d", fail), fail
+}
+
+// Returns the lock of a CDN server
+func (daemon *Daemon) server(name string) *sync.Mutex {
+	daemon.mtx.Lock()
+	defer daemon.mtx.Unlock()
+	if daemon.servers[name] == nil {
+		daemon.servers[name] = &sync.Mutex{}
+	}
+	return daemon.servers[name]
+}
+
+// Saves the queue, must hold mtx
+func (daemon *Daemon) save() error {
+	data, err := json.MarshalIndent(daemon.jobs, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(daemon.path, data, 0666)
+	if err != nil {
+		return fmt.Errorf("error: Saving %v: %v", daemon.path, err)
+	}
+	return nil
+}
+
+// Adds an url entry to the queue
+func (daemon *Daemon) Add(entry any, priority int) (job Job, err error) {
+	err = checkEntry(entry)
+	if err != nil {
+		return
+	}
+	daemon.mtx.Lock()
+	defer daemon.mtx.Unlock()
+	added := &Job{
+		Id:       daemon.nextId,
+		Entry:    entry,
+		Priority: priority,
+		Status:   Queued,
+		Added:    time.Now(),
+	}
+	daemon.nextId++
+	daemon.jobs = append(daemon.jobs, added)
+	defer daemon.poke()
+	return *added, daemon.save()
+}
+
+// Returns an error if the entry is not in the format of the json urls
+func checkEntry(entry any) (err error) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			err = fmt.Errorf("urls are in wrong format, error: %v", r)
+		}
+	}()
+	_, _, duration := config.ParseEntry(entry)
+	if duration == nil {
+		err = fmt.Errorf("timestamps are in wrong format")
+	}
+	return
+}
+
+// Returns a copy of every job, sorted by id
+func (daemon *Daemon) Jobs() []Job {
+	daemon.mtx.Lock()
+	defer daemon.mtx.Unlock()
+	jobs := make([]Job, len(daemon.jobs))
+	for i, job := range daemon.jobs {
+		jobs[i] = *job
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Id < jobs[j].Id })
+	return jobs
+}
+
+// Returns a copy of a job
+func (daemon *Daemon) Job(id int) (Job, error) {
+	daemon.mtx.Lock()
+	defer daemon.mtx.Unlock()
+	job := daemon.find(id)
+	if job == nil {
+		return Job{}, errNotFound
+	}
+	return *job, nil
+}
+
+var errNotFound = fmt.Errorf("job not found")
+
+// Returns the job with the id, must hold mtx
+func (daemon *Daemon) find(id int) *Job {
+	for _, job := range daemon.jobs {
+		if job.Id == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// Pauses a job, a running job keeps its output open and stops before its next segment
+func (daemon *Daemon) Pause(id int) error {
+	return daemon.update(id, func(job *Job) error {
+		switch {
+		case job.control != nil:
+			job.control.Pause()
+		case job.Status != Queued:
+			return fmt.Errorf("job is %v", job.Status)
+		}
+		job.Status = Paused
+		return nil
+	})
+}
+
+// Resumes a paused job
+func (daemon *Daemon) Resume(id int) error {
+	return daemon.update(id, func(job *Job) error {
+		if job.Status != Paused {
+			return fmt.Errorf("job is %v", job.Status)
+		}
+		job.Status = Queued
+		if job.control != nil {
+			job.control.Resume()
+			job.Status = Running
+		}
+		return nil
+	})
+}
+
+// Cancels a job, a running job stops before its next segment and can be retried later
+func (daemon *Daemon) Cancel(id int) error {
+	return daemon.update(id, func(job *Job) error {
+		if job.control != nil {
+			job.control.Cancel()
+			return nil
+		}
+		switch job.Status {
+		case Queued, Paused:
+			job.Status = Cancelled
+			return nil
+		}
+		return fmt.Errorf("job is %v", job.Status)
+	})
+}
+
+// Queues a stopped job again
+func (daemon *Daemon) Retry(id int) error {
+	return daemon.update(id, func(job *Job) error {
+		switch job.Status {
+		case Failed, Cancelled:
+			job.Status = Queued
+			job.Error = ""
+			return nil
+		}
+		return fmt.Errorf("job is %v", job.Status)
+	})
+}
+
+// Changes the priority of a job, higher priorities are started first
+func (daemon *Daemon) Prioritize(id int, priority int) error {
+	return daemon.update(id, func(job *Job) error {
+		job.Priority = priority
+		return nil
+	})
+}
+
+// Removes a job that is not running from the queue
+func (daemon *Daemon) Remove(id int) error {
+	return daemon.update(id, func(job *Job) error {
+		if job.control != nil {
+			return fmt.Errorf("job is running, cancel it first")
+		}
+		for i, j := range daemon.jobs {
+			if j == job {
+				daemon.jobs = append(daemon.jobs[:i], daemon.jobs[i+1:]...)
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// Applies the change to a job and saves the queue
+func (daemon *Daemon) update(id int, change func(job *Job) error) error {
+	daemon.mtx.Lock()
+	defer daemon.mtx.Unlock()
+	job := daemon.find(id)
+	if job == nil {
+		return errNotFound
+	}
+	err := change(job)
+	if err != nil {
+		return err
+	}
+	daemon.poke()
+	return daemon.save()
+}
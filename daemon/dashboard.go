@@ -0,0 +1,75 @@
+package daemon
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"recurbate/config"
+)
+
+//go:embed dashboard.html
+var dashboard []byte
+
+// Serves the dashboard page
+func (daemon *Daemon) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboard)
+}
+
+// GET returns the Cookie and User-Agent of the header, PUT changes them and saves the json
+func (daemon *Daemon) handleHeader(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		reply(w, http.StatusOK, daemon.Header())
+	case http.MethodPut:
+		var header map[string]string
+		err := json.NewDecoder(r.Body).Decode(&header)
+		if err != nil {
+			replyError(w, http.StatusBadRequest, err)
+			return
+		}
+		err = daemon.SetHeader(header)
+		if err != nil {
+			replyError(w, http.StatusInternalServerError, err)
+			return
+		}
+		reply(w, http.StatusOK, daemon.Header())
+	default:
+		replyError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+	}
+}
+
+// Returns the Cookie and User-Agent of the header
+func (daemon *Daemon) Header() map[string]string {
+	daemon.mtx.Lock()
+	defer daemon.mtx.Unlock()
+	return map[string]string{
+		"Cookie":     daemon.cfg.Header["Cookie"],
+		"User-Agent": daemon.cfg.Header["User-Agent"],
+	}
+}
+
+// Changes the Cookie and User-Agent of the header used by the next requests and saves the json
+func (daemon *Daemon) SetHeader(header map[string]string) error {
+	daemon.mtx.Lock()
+	defer daemon.mtx.Unlock()
+	// running jobs may still read the old header
+	changed := make(map[string]string, len(daemon.cfg.Header))
+	for k, v := range daemon.cfg.Header {
+		changed[k] = v
+	}
+	for _, key := range []string{"Cookie", "User-Agent"} {
+		if value, ok := header[key]; ok {
+			changed[key] = value
+		}
+	}
+	daemon.cfg.Header = changed
+	// jobs already running use it from their next request
+	config.SetHeader(changed)
+	return daemon.cfg.Save()
+}
@@ -0,0 +1,168 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defines what is known about a video, zero values are unknown
+type Video struct {
+	Performer string
+	Date      time.Time
+	Duration  time.Duration
+}
+
+// Defines a single filter expression such as "date>=2026-09-01"
+type Filter struct {
+	Field    string
+	Operator string
+	Value    string
+	date     time.Time
+	duration time.Duration
+}
+
+var operators = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// Parses a filter expression of the form <field><operator><value>
+func Parse(expr string) (filter Filter, err error) {
+	index := -1
+	for _, op := range operators {
+		i := strings.Index(expr, op)
+		if i > 0 && (index == -1 || i < index || (i == index && len(op) > len(filter.Operator))) {
+			index = i
+			filter.Operator = op
+		}
+	}
+	if index == -1 {
+		return filter, fmt.Errorf("filter %q has no operator", expr)
+	}
+	filter.Field = strings.ToLower(strings.TrimSpace(expr[:index]))
+	filter.Value = strings.TrimSpace(expr[index+len(filter.Operator):])
+	switch filter.Field {
+	case "date":
+		filter.date, err = time.Parse("2006-01-02", filter.Value)
+		if err != nil {
+			return filter, fmt.Errorf("filter %q: date must be formated as 2006-01-02", expr)
+		}
+	case "duration":
+		filter.duration, err = ParseDuration(filter.Value)
+		if err != nil {
+			return filter, fmt.Errorf("filter %q: %v", expr, err)
+		}
+	case "performer":
+		if filter.Operator != "=" && filter.Operator != "!=" {
+			return filter, fmt.Errorf("filter %q: performer only supports = and !=", expr)
+		}
+	default:
+		return filter, fmt.Errorf("filter %q: unknown field %q", expr, filter.Field)
+	}
+	return
+}
+
+// Parses a list of filter expressions
+func ParseAll(exprs []string) (filters []Filter, err error) {
+	for _, expr := range exprs {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+		filter, err := Parse(expr)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return
+}
+
+// Parses durations given as minutes, h:mm:ss or Go durations such as 1h30m
+func ParseDuration(str string) (time.Duration, error) {
+	if minutes, err := strconv.ParseFloat(str, 64); err == nil {
+		return time.Duration(minutes * float64(time.Minute)), nil
+	}
+	if strings.Contains(str, ":") {
+		var secs int
+		for _, part := range strings.Split(str, ":") {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("duration %q is in wrong format", str)
+			}
+			secs = secs*60 + n
+		}
+		return time.Duration(secs) * time.Second, nil
+	}
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("duration %q is in wrong format", str)
+	}
+	return duration, nil
+}
+
+// Reports whether the video passes the filter, unknown fields always pass
+func (filter Filter) Match(video Video) bool {
+	var cmp int
+	switch filter.Field {
+	case "date":
+		if video.Date.IsZero() {
+			return true
+		}
+		day := time.Date(video.Date.Year(), video.Date.Month(), video.Date.Day(), 0, 0, 0, 0, time.UTC)
+		cmp = compare(day.Unix(), filter.date.Unix())
+	case "duration":
+		if video.Duration == 0 {
+			return true
+		}
+		cmp = compare(int64(video.Duration), int64(filter.duration))
+	case "performer":
+		if video.Performer == "" {
+			return true
+		}
+		match := false
+		for _, name := range strings.Split(filter.Value, ",") {
+			if strings.EqualFold(strings.TrimSpace(name), video.Performer) {
+				match = true
+			}
+		}
+		return match == (filter.Operator == "=")
+	}
+	switch filter.Operator {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// Reports whether the video passes every filter, returns the first filter that failed
+func MatchAll(filters []Filter, video Video) (bool, Filter) {
+	for _, filter := range filters {
+		if !filter.Match(video) {
+			return false, filter
+		}
+	}
+	return true, Filter{}
+}
+
+// Returns the expression of the filter
+func (filter Filter) String() string {
+	return filter.Field + filter.Operator + filter.Value
+}
+
+func compare(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
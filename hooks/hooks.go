@@ -0,0 +1,122 @@
+package hooks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"recurbate/tools"
+	"strconv"
+	"time"
+)
+
+// Defines an external command run on a download event
+type Hook struct {
+	Command []string `json:"command"`
+	// time the command may run, e.g. 30s, defaults to 1m
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Defines the hooks of every event
+type Hooks struct {
+	Completed []Hook `json:"completed,omitempty"`
+	Failed    []Hook `json:"failed,omitempty"`
+	Partial   []Hook `json:"partial,omitempty"`
+}
+
+// Defines the document passed to a hook on stdin, and as RECU_* environment variables
+type Payload struct {
+	Event    string  `json:"event"`
+	Url      string  `json:"url"`
+	Filename string  `json:"filename"`
+	Path     string  `json:"path,omitempty"`
+	Segments int     `json:"segments"`
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Checks that every hook has a command and a valid timeout
+func (hooks *Hooks) Validate() error {
+	if hooks == nil {
+		return nil
+	}
+	for _, list := range [][]Hook{hooks.Completed, hooks.Failed, hooks.Partial} {
+		for _, hook := range list {
+			if len(hook.Command) == 0 {
+				return fmt.Errorf("hook has no command")
+			}
+			if hook.Timeout != "" {
+				if _, err := time.ParseDuration(hook.Timeout); err != nil {
+					return fmt.Errorf("hook %v: %v", hook.Command[0], err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Runs the hooks of the payload's event one after another
+func (hooks *Hooks) Run(payload Payload) {
+	if hooks == nil {
+		return
+	}
+	var list []Hook
+	switch payload.Event {
+	case "completed":
+		list = hooks.Completed
+	case "failed", Cloudflare, Login, DailyLimit:
+		list = hooks.Failed
+	case "partial":
+		list = hooks.Partial
+	}
+	for _, hook := range list {
+		err := hook.run(payload)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Hook %v failed: %v\n", hook.Command[0], err)
+			tools.Warn("hook failed", "event", payload.Event, "command", hook.Command[0], "error", err)
+		}
+	}
+}
+
+// Runs the hook with the payload, its output is logged line by line
+func (hook Hook) run(payload Payload) error {
+	if len(hook.Command) == 0 {
+		return fmt.Errorf("no command")
+	}
+	timeout, err := time.ParseDuration(hook.Timeout)
+	if err != nil || timeout <= 0 {
+		timeout = time.Minute
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"RECU_EVENT="+payload.Event,
+		"RECU_URL="+payload.Url,
+		"RECU_FILENAME="+payload.Filename,
+		"RECU_PATH="+payload.Path,
+		"RECU_SEGMENTS="+strconv.Itoa(payload.Segments),
+		"RECU_BYTES="+strconv.FormatInt(payload.Bytes, 10),
+		"RECU_DURATION="+strconv.FormatFloat(payload.Duration, 'f', 3, 64),
+		"RECU_ERROR="+payload.Error,
+		"RECU_JSON="+string(data),
+	)
+	output, err := cmd.CombinedOutput()
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fmt.Printf("[%s] %s\n", hook.Command[0], scanner.Text())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"recurbate/tools"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// attempts made to deliver a webhook before it is dropped
+const webhookAttempts = 5
+
+var pending sync.WaitGroup
+
+// Defines an url notified on download events
+type Webhook struct {
+	Url string `json:"url"`
+	// json, discord or slack, defaults to json
+	Format string `json:"format,omitempty"`
+	// events sent to the url, every event when empty
+	Events []string `json:"events,omitempty"`
+}
+
+// Events sent to webhooks, along with completed, failed and partial
+const (
+	Cloudflare = "cloudflare"
+	Login      = "login"
+	DailyLimit = "daily_limit"
+)
+
+// Checks the format and events of every webhook
+func ValidateWebhooks(webhooks []Webhook) error {
+	for _, webhook := range webhooks {
+		if webhook.Url == "" {
+			return fmt.Errorf("webhook has no url")
+		}
+		switch webhook.Format {
+		case "", "json", "discord", "slack":
+		default:
+			return fmt.Errorf("webhook %v: unknown format %q", webhook.Url, webhook.Format)
+		}
+		for _, event := range webhook.Events {
+			switch event {
+			case "completed", "failed", "partial", Cloudflare, Login, DailyLimit:
+			default:
+				return fmt.Errorf("webhook %v: unknown event %q", webhook.Url, event)
+			}
+		}
+	}
+	return nil
+}
+
+// Sends the payload to every webhook subscribed to its event, delivery happens in the background
+func Notify(webhooks []Webhook, payload Payload) {
+	for _, webhook := range webhooks {
+		if !webhook.subscribed(payload.Event) {
+			continue
+		}
+		body, err := webhook.body(payload)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Webhook %v failed: %v\n", webhook.Url, err)
+			continue
+		}
+		pending.Add(1)
+		go func(webhook Webhook) {
+			defer pending.Done()
+			err := webhook.deliver(body)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Webhook %v failed: %v\n", webhook.Url, err)
+				tools.Warn("webhook failed", "event", payload.Event, "url", webhook.Url, "error", err)
+			}
+		}(webhook)
+	}
+}
+
+// Waits for webhooks still being delivered
+func Wait() {
+	pending.Wait()
+}
+
+// Returns if the webhook wants the event
+func (webhook Webhook) subscribed(event string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the request body in the format of the webhook
+func (webhook Webhook) body(payload Payload) ([]byte, error) {
+	switch webhook.Format {
+	case "discord":
+		return json.Marshal(map[string]string{"content": payload.Message()})
+	case "slack":
+		return json.Marshal(map[string]string{"text": payload.Message()})
+	}
+	return json.Marshal(payload)
+}
+
+// Posts the body, retrying with exponential backoff on network errors, 429 and 5xx responses
+func (webhook Webhook) deliver(body []byte) (err error) {
+	header := map[string]string{"Content-Type": "application/json"}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		data, status, respHeader, reqErr := tools.RequestHeader(webhook.Url, 10, header, body, "POST")
+		switch {
+		case reqErr != nil:
+			err = reqErr
+		case status >= 200 && status < 300:
+			return nil
+		case status == 429 || status >= 500:
+			err = fmt.Errorf("status code: %d, %s", status, tools.ShortenString(string(data), 200))
+			// discord and slack say how long to wait
+			if secs, convErr := strconv.ParseFloat(respHeader.Get("Retry-After"), 64); convErr == nil && secs > 0 {
+				backoff = time.Duration(secs * float64(time.Second))
+			}
+		default:
+			return fmt.Errorf("status code: %d, %s", status, tools.ShortenString(string(data), 200))
+		}
+		if attempt == webhookAttempts {
+			return fmt.Errorf("gave up after %d attempts: %v", attempt, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Returns a one line description of the payload for chat webhooks
+func (payload Payload) Message() string {
+	name := payload.Filename
+	if name == "" {
+		name = payload.Url
+	}
+	switch payload.Event {
+	case "completed":
+		return fmt.Sprintf("Completed: %s (%d segments, %s, %s)", name, payload.Segments, tools.FormatBytes(float64(payload.Bytes)), time.Duration(payload.Duration*float64(time.Second)).Round(time.Second))
+	case "partial":
+		return fmt.Sprintf("Partial: %s, %s after %d segments", name, payload.Error, payload.Segments)
+	case Cloudflare:
+		return fmt.Sprintf("Cloudflare Blocked: %s", payload.Url)
+	case Login:
+		return fmt.Sprintf("Please Log in: %s", payload.Url)
+	case DailyLimit:
+		return fmt.Sprintf("Daily View Used: %s", payload.Url)
+	}
+	return fmt.Sprintf("Failed: %s, %s", name, payload.Error)
+}
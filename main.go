@@ -1,107 +1,175 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"io/fs"
+	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"recurbate/config"
+	"recurbate/daemon"
+	"recurbate/hooks"
+	"recurbate/metrics"
 	"recurbate/playlist"
+	"recurbate/recu"
+	"recurbate/serve"
 	"recurbate/tools"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 )
 
 var tag string
 
-func parallelService(cfg config.Config) {
-	playlists := make([]playlist.Playlist, len(cfg.Urls))
-	for i, link := range cfg.Urls {
-		playlists[i] = cfg.GetPlaylist(link, i)
+// Defines a video to download and the json its url was read from
+type task struct {
+	cfg      config.Config
+	playList playlist.Playlist
+	// videos with the same key are downloaded one after another
+	key string
+	// index to resume at returned by GetVideo, 0 once complete
+	fail int
+}
+
+// Downloads videos with the mode, parallel starts every video, series one video at a time
+// and hybrid one video at a time from each server
+type scheduler struct {
+	mode    string
+	pending []task
+	busy    map[string]bool
+	running int
+	// videos added and started, numbered in series mode
+	total   int
+	started int
+	done    chan task
+	// video ids of the urls downloaded or skipped without an error
+	finished []string
+}
+
+func newScheduler(mode string) *scheduler {
+	return &scheduler{
+		mode: mode,
+		busy: make(map[string]bool),
+		done: make(chan task),
+	}
+}
+
+// Resolves and queues the urls at the json locations, every url if locs is nil
+func (s *scheduler) add(cfg config.Config, locs []int) {
+	if locs == nil {
+		locs = make([]int, len(cfg.Urls))
+		for i := range locs {
+			locs[i] = i
+		}
+	}
+	playlists := make([]playlist.Playlist, len(locs))
+	for i, loc := range locs {
+		var err error
+		playlists[i], err = cfg.FetchPlaylist(cfg.Urls[loc], loc)
+		// archived and filtered videos are done without downloading
+		if err == nil && playlists[i].IsNil() {
+			s.finished = append(s.finished, recu.VideoId(config.EntryUrl(cfg.Urls[loc])))
+		}
 	}
-	var wg sync.WaitGroup
 	for _, playList := range playlists {
 		if playList.IsNil() {
 			continue
 		}
-		wg.Add(1)
-		go func(playList playlist.Playlist) {
-			defer wg.Done()
-			if cfg.GetVideo(playList) == 0 {
-				return
-			}
-			err := os.WriteFile(playList.Filename+".m3u8", playList.M3u8, 0666)
+		s.total++
+		key := strconv.Itoa(s.total)
+		switch s.mode {
+		case "series":
+			key = ""
+		case "hybrid":
+			// organize playlist by server
+			server, err := playList.PlaylistOrigin()
 			if err != nil {
-				fmt.Println(playList.M3u8)
-				fmt.Fprintf(os.Stderr, "Failed to write playlist data: %v\n", err)
+				fmt.Fprintln(os.Stderr, err)
+				continue
 			}
-		}(playList)
-		time.Sleep(time.Second)
+			key = server
+		}
+		s.pending = append(s.pending, task{cfg: cfg, playList: playList, key: key})
 	}
-	wg.Wait()
 }
 
-func hybridService(cfg config.Config) {
-	playlists := make([]playlist.Playlist, len(cfg.Urls))
-	for i, link := range cfg.Urls {
-		playlists[i] = cfg.GetPlaylist(link, i)
-	}
-	servers := make(map[string][]playlist.Playlist)
-	// organize playlist by server
-	for _, playList := range playlists {
-		server, err := playList.PlaylistOrigin()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+// Starts the queued videos that can start
+func (s *scheduler) start() {
+	pending := s.pending[:0]
+	for _, t := range s.pending {
+		if tools.Abort || s.busy[t.key] {
+			pending = append(pending, t)
 			continue
 		}
-		if servers[server] == nil {
-			servers[server] = make([]playlist.Playlist, 0)
+		if s.mode == "parallel" && s.running > 0 {
+			time.Sleep(time.Second)
 		}
-		servers[server] = append(servers[server], playList)
+		s.busy[t.key] = true
+		s.running++
+		s.started++
+		if s.mode == "series" {
+			fmt.Printf("%d/%d:\n", s.started, s.total)
+		}
+		go func(t task) {
+			t.fail = t.cfg.GetVideo(t.playList)
+			s.done <- t
+		}(t)
 	}
-	var wg sync.WaitGroup
-	for _, playlists := range servers {
-		wg.Add(1)
-		go func(playlists []playlist.Playlist) {
-			defer wg.Done()
-			for _, playList := range playlists {
-				if playList.IsNil() {
-					continue
-				}
-				if cfg.GetVideo(playList) == 0 {
-					continue
-				}
-				err := os.WriteFile(playList.Filename+".m3u8", playList.M3u8, 0666)
-				if err != nil {
-					fmt.Println(playList.M3u8)
-					fmt.Fprintf(os.Stderr, "Failed to write playlist data: %v\n", err)
-				}
+	s.pending = pending
+}
+
+// Downloads every queued video, the json is reloaded when it changes until the last video is done
+func (s *scheduler) run(cfg config.Config) {
+	reloads := config.Watch()
+	defer reloadOnHangup()()
+	for {
+		s.start()
+		if s.running == 0 {
+			return
+		}
+		select {
+		case t := <-s.done:
+			s.running--
+			delete(s.busy, t.key)
+			if t.fail == 0 {
+				s.finished = append(s.finished, recu.VideoId(config.EntryUrl(t.cfg.Urls[t.playList.JsonLoc])))
 			}
-		}(playlists)
+		case <-reloads:
+			cfg = s.reload(cfg)
+		}
 	}
-	wg.Wait()
 }
-func serialService(cfg config.Config) {
-	playlists := make([]playlist.Playlist, len(cfg.Urls))
-	for i, link := range cfg.Urls {
-		playlists[i] = cfg.GetPlaylist(link, i)
+
+// Reads the json again, urls added to it are queued and the queued videos of urls removed from it are dropped.
+// Running videos keep going and use the new header from their next request
+func (s *scheduler) reload(cfg config.Config) config.Config {
+	reloaded, err := cfg.Reload()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Reloading Json: %v\n", err)
+		tools.Warn("json reload failed", "error", err)
+		return cfg
 	}
-	for i, playList := range playlists {
-		if playList.IsNil() {
-			continue
-		}
-		fmt.Printf("%d/%d:\n", i+1, len(playlists))
-		if cfg.GetVideo(playList) == 0 {
+	added, removed := cfg.Changes(reloaded)
+	pending := s.pending[:0]
+	for _, t := range s.pending {
+		if removed[config.EntryUrl(t.cfg.Urls[t.playList.JsonLoc])] {
+			fmt.Printf("Removed from the json: %v\n", t.playList.Filename)
+			s.total--
 			continue
 		}
-		err := os.WriteFile(playList.Filename+".m3u8", playList.M3u8, 0666)
-		if err != nil {
-			fmt.Println(playList.M3u8)
-			fmt.Fprintf(os.Stderr, "Failed to write playlist data: %v\n", err)
-		}
+		pending = append(pending, t)
+	}
+	s.pending = pending
+	tools.Info("json reloaded", "added", len(added), "removed", len(removed))
+	if len(added) > 0 {
+		fmt.Printf("Queued %d videos added to the json\n", len(added))
+		s.add(reloaded, added)
 	}
+	return reloaded
 }
 func downloadPlaylist(cfg config.Config) {
 	for i, v := range cfg.Urls {
@@ -109,7 +177,12 @@ func downloadPlaylist(cfg config.Config) {
 		if playList.IsNil() {
 			continue
 		}
-		err := os.WriteFile(playList.Filename+".m3u8", playList.M3u8, 0666)
+		err := playList.MakeDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create directory: %v\n", err)
+			continue
+		}
+		err = os.WriteFile(playList.Filename+".m3u8", playList.M3u8, 0666)
 		if err != nil {
 			fmt.Println(playList.M3u8)
 			fmt.Fprintf(os.Stderr, "Failed to write playlist data: %v\n", err)
@@ -132,7 +205,427 @@ func downloadConent(cfg config.Config) {
 	}
 	filename = strings.ReplaceAll(filename, ".m3u8", "")
 	playList := playlist.NewFromFilename(data, filename, 0)
-	cfg.GetVideo(playList)
+	if !cfg.Matches(playList) {
+		return
+	}
+	// the video is not an url of the json, so nothing is saved to it
+	fail, _ := cfg.DownloadVideo(nil, playList, cfg.Options())
+	if fail != 0 && fail != recu.NotStarted {
+		fmt.Fprintf(os.Stderr, "Download Failed at line: %v\n", fail)
+	}
+}
+func crawl(cfg config.Config) {
+	performerUrl := tools.Argparser(3)
+	if performerUrl == "" {
+		fmt.Fprintln(os.Stderr, "Please specify a performer url to crawl")
+		return
+	}
+	maxPages, _ := strconv.Atoi(tools.Argparser(4))
+	listings, err := recu.Crawl(performerUrl, cfg.Header, maxPages)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to crawl %v: %v\n", performerUrl, err)
+		if len(listings) == 0 {
+			return
+		}
+	}
+	added := cfg.AddListings(listings)
+	err = cfg.Save()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Printf("Added %d of %d videos to the json\n", len(added), len(listings))
+}
+
+// Verifies a .ts file against its playlist, re-downloading the damaged segments if repair is set
+func verify(cfg config.Config, repair bool) {
+	tsPath := tools.Argparser(3)
+	playlistPath := tools.Argparser(4)
+	if tsPath == "" {
+		fmt.Fprintln(os.Stderr, "Please specify the .ts file and its .m3u8 playlist")
+		return
+	}
+	if playlistPath == "" {
+		playlistPath = strings.TrimSuffix(tsPath, ".ts") + ".m3u8"
+	}
+	data, err := os.ReadFile(playlistPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read playlist: %v\n", err)
+		return
+	}
+	playList := playlist.NewFromFilename(data, strings.TrimSuffix(tsPath, ".ts"), 0)
+	damages, err := recu.Verify(tsPath, playList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to verify %v: %v\n", tsPath, err)
+		return
+	}
+	if len(damages) == 0 {
+		fmt.Printf("Verified: %v has no damage\n", tsPath)
+		return
+	}
+	for _, damage := range damages {
+		segment := "unknown"
+		if damage.Index >= 0 {
+			segment = strconv.Itoa(damage.Index)
+		}
+		fmt.Printf("Segment: %s\tOffset: %d\tSize: %d\t%s\n", segment, damage.Offset, damage.Size, tools.ANSIColor(damage.Reason, 31))
+	}
+	fmt.Printf("%v has %d damaged ranges\n", tsPath, len(damages))
+	if !repair {
+		return
+	}
+	err = recu.Repair(tsPath, playList, tools.FormatedHeader(cfg.Header, "", 0), damages)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to repair %v: %v\n", tsPath, err)
+		return
+	}
+	fmt.Printf("Repaired: %v\n", tsPath)
+}
+
+// Defines a row of the dry run table
+type dryRunRow struct {
+	filename string
+	variant  string
+	segments int
+	duration time.Duration
+	size     int64
+	server   string
+	err      string
+}
+
+// Returns the row of a resolved playlist, only the part of it that would be downloaded is counted
+func dryRunPlaylist(cfg config.Config, playList playlist.Playlist, percent []float64) (row dryRunRow) {
+	row.filename = playList.Filename
+	row.variant = playList.Quality
+	if playList.Bandwidth > 0 {
+		row.variant += fmt.Sprintf(" %s", tools.FormatBytesPerSecond(float64(playList.Bandwidth)/8))
+	}
+	// clamped like Mux does, a range that is empty or not a number downloads nothing
+	from, to := percent[0], percent[1]
+	if math.IsNaN(from) || math.IsNaN(to) || from > 100 || to <= from {
+		from, to = 0, 0
+	}
+	if from < 0 {
+		from = 0
+	}
+	if to > 100 {
+		to = 100
+	}
+	start := int(float64(playList.Len()) * from / 100)
+	end := int(float64(playList.Len()) * to / 100)
+	if end > playList.Len() {
+		end = playList.Len()
+	}
+	if start > end {
+		start = end
+	}
+	row.segments = end - start
+	var secs float64
+	for _, duration := range playList.Durations[start:end] {
+		secs += duration
+	}
+	row.duration = time.Duration(secs * float64(time.Second))
+	row.size = recu.EstimateSize(playList, tools.FormatedHeader(cfg.Header, "", 0), start, end)
+	server, err := playList.PlaylistOrigin()
+	if err != nil {
+		row.err = err.Error()
+	}
+	row.server = server
+	return
+}
+
+// Resolves the playlist of every url and prints what downloading them would cost, nothing is written to disk
+func dryRun(cfg config.Config) {
+	var rows []dryRunRow
+	for i, entry := range cfg.Urls {
+		if tools.Abort {
+			break
+		}
+		playList, err := cfg.ResolvePlaylist(entry, i)
+		if err != nil {
+			rows = append(rows, dryRunRow{filename: config.EntryUrl(entry), err: tools.ShortenString(strings.ReplaceAll(err.Error(), "\n", " "), 80)})
+			continue
+		}
+		if playList.IsNil() {
+			continue
+		}
+		_, _, percent := config.ParseEntry(entry)
+		rows = append(rows, dryRunPlaylist(cfg, playList, percent))
+	}
+	printDryRun(rows)
+}
+
+// Prints what downloading the saved playlists would cost without using any views, every
+// .m3u8 in the working directory is read if no files are given
+func dryRunCached(cfg config.Config, files []string) {
+	if len(files) == 0 {
+		filepath.WalkDir(".", func(path string, entry fs.DirEntry, err error) error {
+			// local playlists of segment stores are not saved playlists
+			if err == nil && !entry.IsDir() && filepath.Ext(path) == ".m3u8" && entry.Name() != recu.StorePlaylist {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	var rows []dryRunRow
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			rows = append(rows, dryRunRow{filename: file, err: err.Error()})
+			continue
+		}
+		playList := playlist.NewFromFilename(data, strings.TrimSuffix(file, ".m3u8"), 0)
+		rows = append(rows, dryRunPlaylist(cfg, playList, []float64{0, 100}))
+	}
+	printDryRun(rows)
+}
+
+// Prints the dry run table with a total
+func printDryRun(rows []dryRunRow) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Filename\tVariant\tSegments\tDuration\tEst. Size\tServer\tError")
+	var segments int
+	var duration time.Duration
+	var size int64
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", row.filename, row.variant, row.segments, tools.FormatMinutes(row.duration.Minutes()), tools.FormatBytes(float64(row.size)), row.server, row.err)
+		segments += row.segments
+		duration += row.duration
+		size += row.size
+	}
+	fmt.Fprintf(w, "Total: %d videos\t\t%d\t%s\t%s\t\t\n", len(rows), segments, tools.FormatMinutes(duration.Minutes()), tools.FormatBytes(float64(size)))
+	w.Flush()
+}
+
+// Builds a single .ts file, or an MP4, from a segment store
+func assemble() {
+	dir := tools.Argparser(3)
+	if dir == "" {
+		fmt.Fprintln(os.Stderr, "Please specify the directory of the segments")
+		return
+	}
+	path, err := recu.Assemble(dir, tools.Argparser(4) == "mp4")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to assemble %v: %v\n", dir, err)
+		return
+	}
+	fmt.Printf("Assembled: %v\n", path)
+}
+
+// Polls the followed performers and downloads new videos until aborted
+func watch(cfg config.Config) {
+	if len(cfg.Follow) == 0 {
+		fmt.Fprintln(os.Stderr, "Please add performer urls to follow to the json")
+		return
+	}
+	seen, err := config.LoadSeen()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	mode := tools.Argparser(3)
+	for !tools.Abort {
+		var queue []recu.Listing
+		// performer of every queued video, a video is seen once it is downloaded or skipped
+		performers := make(map[string]string)
+		for _, performer := range cfg.Follow {
+			if tools.Abort {
+				return
+			}
+			// new videos are listed first, so the first page is enough
+			listings, err := recu.Crawl(performer, cfg.Header, 1)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to poll %v: %v\n", performer, err)
+				continue
+			}
+			known := seen.Known(performer)
+			ids := make([]string, 0, len(listings))
+			for _, listing := range listings {
+				if seen.Has(performer, listing.Id) {
+					continue
+				}
+				ids = append(ids, listing.Id)
+				// the first poll only records what is already there
+				if known {
+					queue = append(queue, listing)
+					performers[listing.Id] = performer
+				}
+			}
+			if !known {
+				fmt.Printf("Following %v: %d existing videos marked as seen\n", performer, len(ids))
+				err = seen.Add(performer, ids...)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+			}
+			time.Sleep(time.Second)
+		}
+		if len(queue) > 0 {
+			added := cfg.AddListings(queue)
+			err = cfg.Save()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			fmt.Printf("Queued %d new videos\n", len(added))
+			// videos queued before that did not finish, e.g. failed or interrupted, are downloaded again
+			ids := make([]string, len(queue))
+			for i, listing := range queue {
+				ids[i] = listing.Id
+			}
+			locs := cfg.Locations(ids)
+			if retried := len(locs) - len(added); retried > 0 {
+				fmt.Printf("Queued %d videos that did not finish before\n", retried)
+			}
+			for _, id := range run(cfg, mode, locs) {
+				performer, ok := performers[id]
+				if !ok {
+					continue
+				}
+				err = seen.Add(performer, id)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+			}
+		}
+		next := cfg.NextPoll()
+		fmt.Printf("Next poll at %v\n", time.Now().Add(next).Format("15:04:05"))
+		for wait := time.Now().Add(next); time.Now().Before(wait) && !tools.Abort; {
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// Downloads the urls at the json locations with the given mode, every url if locs is nil.
+// Urls added to the json while downloading are downloaded too. Returns the video ids
+// of the urls downloaded or skipped without an error
+func run(cfg config.Config, mode string, locs []int) (finished []string) {
+	// videos streamed to stdout must follow each other
+	if cfg.Options().Target == "-" {
+		mode = "series"
+	}
+	keysOnce.Do(handleKeys)
+	switch mode {
+	case "series", "hybrid":
+	default:
+		mode = "parallel"
+	}
+	s := newScheduler(mode)
+	s.add(cfg, locs)
+	if mode != "series" {
+		defer tools.StartProgress()()
+	}
+	s.run(cfg)
+	return s.finished
+}
+
+var keysOnce sync.Once
+
+// Pauses and resumes downloads with the keyboard, p and r pause and resume every download,
+// a number typed before them only the download with that number on its progress line
+func handleKeys() {
+	if tools.Plain || tools.JSONOutput || !tools.IsTerminal(os.Stdin) {
+		return
+	}
+	keys, err := tools.ReadKeys()
+	if err != nil {
+		tools.Debug("can not read keys", "error", err)
+		return
+	}
+	go func() {
+		var id int
+		for key := range keys {
+			switch key {
+			case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+				id = id*10 + int(key-'0')
+				continue
+			case 'p', 'P':
+				if id == 0 {
+					recu.PauseAll()
+					fmt.Println("Paused every download")
+				} else if recu.PauseJob(id) {
+					fmt.Printf("Paused download %d\n", id)
+				} else {
+					fmt.Printf("Download %d is not running\n", id)
+				}
+			case 'r', 'R':
+				if id == 0 {
+					recu.ResumeAll()
+					fmt.Println("Resumed every download")
+				} else if recu.ResumeJob(id) {
+					fmt.Printf("Resumed download %d\n", id)
+				} else {
+					fmt.Printf("Download %d is not running\n", id)
+				}
+			}
+			id = 0
+		}
+	}()
+}
+
+func runDaemon(cfg *config.Config) {
+	addr := tools.Argparser(3)
+	if addr == "" {
+		addr = "localhost:8080"
+	}
+	var limit int
+	if jobs, ok := tools.Option("jobs"); ok {
+		var err error
+		limit, err = strconv.Atoi(jobs)
+		if err != nil || limit < 0 {
+			fmt.Fprintf(os.Stderr, "Error: --jobs must be a number: %v\n", jobs)
+			os.Exit(4)
+		}
+	}
+	d, err := daemon.New(cfg, config.Path("queue.json"), tools.Argparser(4), limit)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(4)
+	}
+	_, d.Dashboard = tools.Option("dashboard")
+	err = d.Run(addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(4)
+	}
+}
+
+// Sets up logging from the --log-* options, records are only written with --log-file or --log-level
+func setupLog() error {
+	file, hasFile := tools.Option("log-file")
+	name, hasLevel := tools.Option("log-level")
+	if !hasFile && !hasLevel {
+		return nil
+	}
+	level := tools.LevelInfo
+	if hasLevel {
+		var err error
+		level, err = tools.ParseLevel(name)
+		if err != nil {
+			return err
+		}
+	}
+	maxSize := int64(10e6)
+	if size, ok := tools.Option("log-max-size"); ok {
+		var err error
+		maxSize, err = tools.ParseBytes(size)
+		if err != nil {
+			return fmt.Errorf("--log-max-size: %v", err)
+		}
+	}
+	backups := 3
+	if num, ok := tools.Option("log-backups"); ok {
+		var err error
+		backups, err = strconv.Atoi(num)
+		if err != nil || backups < 0 {
+			return fmt.Errorf("--log-backups must be a number: %v", num)
+		}
+	}
+	err := tools.SetupLog(file, level, maxSize, backups)
+	if err != nil {
+		return err
+	}
+	tools.Info("started", "version", tag, "args", strings.Join(os.Args[1:], " "))
+	return nil
 }
 func readme() string {
 	path := tools.Argparser(0)
@@ -147,7 +640,11 @@ Fill in the json's URL, Cookie and User-Agent to allow the
 	program to run
 
 Usage: `
-	string2 := ` <json location> playlist|series <playlist.m3u8>
+	string2 := ` <json location> playlist|series|hybrid|crawl|watch|archive <playlist.m3u8|performer url|mode>
+	or ` + path + ` <json location> verify|repair <file.ts> <playlist.m3u8>
+	or ` + path + ` <json location> assemble <directory> ts|mp4
+	or ` + path + ` <json location> serve <address>
+	or ` + path + ` <json location> daemon <address> parallel|series|hybrid
 
 if "playlist" is used, only the .m3u8 playlist file will be
 	downloaded, specifiying the playlist location will
@@ -155,7 +652,82 @@ if "playlist" is used, only the .m3u8 playlist file will be
 if "series" is used, the program will download all the videos
 	in series
 if "hybrid is used, the program will download sequentially from
-	each server but in parallel from different servers`
+	each server but in parallel from different servers
+if "crawl" is used, every video on the performer page, along
+	with its date and duration, will be added to the json
+if "watch" is used, the json's followed performers will be polled
+	and new videos downloaded with the given mode
+if "archive" is used, the archive is rebuilt from the completed
+	.ts files in the working directory
+if "verify" is used, the .ts file is checked against its playlist
+	and the damaged segments are listed, "repair" will also
+	download those segments again and splice them in
+if "assemble" is used, the segments downloaded with
+	--store=segments are joined into a single .ts or .mp4
+if "serve" is used, the working directory is served over HTTP
+	with a playlist of every download that can be watched
+	while it is downloading
+if "daemon" is used, a queue of jobs is downloaded with the
+	given mode and controlled through a REST API on the
+	address, the queue is kept in queue.json
+
+Pausing:
+SIGUSR1 pauses every download and SIGUSR2 resumes them, on a
+	terminal p and r pause and resume every download, and a
+	number typed before them, e.g. 2p, only the download with
+	that number on its progress line
+
+Reloading:
+the json is read again when it is saved or on SIGHUP, added urls
+	are queued, videos of removed urls that have not started
+	are dropped and a changed header is used from the next
+	request
+
+Options:
+--filter=<expression>	only download videos matching the
+	expression, e.g. --filter="date>=2026-09-01" or
+	--filter="duration>30m", may be given several times
+--archive=<file>	skip videos listed in the archive file and
+	add completed videos to it
+--existing=<policy>	what to do when the video already exists,
+	skip, overwrite, verify or rename (default)
+--store=segments	save every segment in its own file in a
+	directory with a playlist that can be played while
+	downloading
+--min-free=<size>	disk space to keep free, e.g. 5GB, downloads
+	that do not fit are queued or refused and running
+	downloads pause below it, defaults to 1GB
+--dry-run	resolve every playlist and print the filename,
+	variant, segments, duration, estimated size and server
+	of each video without downloading or saving anything,
+	resolving a playlist uses a view
+--cached	with --dry-run, read the saved .m3u8 playlists in
+	the working directory instead of using views
+--dashboard	with daemon, serve a web page on the address to add
+	videos, edit the Cookie and User-Agent and follow the
+	progress of every job
+--jobs=<n>	with daemon, the number of jobs downloaded at
+	once in parallel and hybrid mode, unlimited by default
+--no-color	do not color the output, also disabled by the
+	NO_COLOR environment variable, when the output is not a
+	terminal progress is printed every 10 seconds as
+	timestamped lines instead of being redrawn
+--output=json	write events as JSON lines to stdout, every other
+	message is written to stderr
+--progress-interval=<duration>	time between progress events
+	with --output=json, defaults to 5s
+--log-file=<file>	write a log of requests, retries, timings and
+	resume points to the file, cookies are redacted
+--log-level=<level>	debug, info (default), warn or error, logs to
+	stderr if no --log-file is given
+--log-max-size=<size>	size the log file is rotated at, defaults
+	to 10MB
+--log-backups=<n>	rotated log files kept, defaults to 3
+--metrics=<address>	serve Prometheus metrics on
+	http://<address>/metrics, the daemon also serves them on
+	its own address
+--target=-	write the video to stdout instead of a file, every
+	message is written to stderr and resuming is disabled`
 	return string1 + path + string2
 }
 func init() {
@@ -167,13 +739,55 @@ func init() {
 		force := make(chan os.Signal, 1)
 		signal.Notify(force, os.Interrupt, syscall.SIGTERM)
 		<-force
+		tools.RestoreTerminal()
 		os.Exit(0)
 	}()
 }
 func main() {
+	// when streaming to stdout every message goes to stderr, tools.Stdout keeps the real stdout
+	target, _ := tools.Option("target")
+	output, _ := tools.Option("output")
+	switch output {
+	case "", "text":
+	case "json":
+		if target == "-" {
+			fmt.Fprintln(os.Stderr, "Error: --output=json can not be used with --target=-")
+			os.Exit(4)
+		}
+		tools.JSONOutput = true
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown output: %v\n", output)
+		os.Exit(4)
+	}
+	if interval, ok := tools.Option("progress-interval"); ok {
+		duration, err := time.ParseDuration(interval)
+		if err != nil || duration <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: --progress-interval must be a duration like 10s: %v\n", interval)
+			os.Exit(4)
+		}
+		tools.ProgressInterval = duration
+	}
+	if target == "-" || tools.JSONOutput {
+		os.Stdout = os.Stderr
+	}
+	err := setupLog()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(4)
+	}
+	if addr, ok := tools.Option("metrics"); ok {
+		go func() {
+			err := metrics.Serve(addr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: Serving Metrics: %v\n", err)
+			}
+		}()
+	}
+	tools.SetupTerminal()
+	defer tools.RestoreTerminal()
 	fmt.Printf("Recu %v\n", tag)
 	tools.CheckUpdate(tag)
-	if tools.Argparser(1) == "--help" {
+	if _, help := tools.Option("help"); help {
 		fmt.Println(readme())
 		return
 	}
@@ -181,24 +795,80 @@ func main() {
 	if tools.Argparser(1) != "" {
 		json_location = tools.Argparser(1)
 	}
-	_, err := os.Stat(json_location)
+	_, err = os.Stat(json_location)
 	if err != nil {
 		defaultConfig := config.Default()
 		defaultConfig.Save()
 		fmt.Printf("%v created in working directory\nPlease fill in the %v with the \n\tURLs to Download\n\tCookies\n\tUser-Agent\n", json_location, json_location)
 		return
 	}
-	jsonData, err := os.ReadFile(json_location)
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Reading Json: %v\n", err)
+		os.Exit(4)
+	}
+	tools.Debug("loaded json", "path", json_location, "urls", len(cfg.Urls), "header", cfg.Header)
+	err = cfg.Validate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Reading Json: %v\n", err)
+		os.Exit(4)
+	}
+	err = cfg.LoadFilters()
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintf(os.Stderr, "Error: Parsing Filters: %v\n", err)
 		os.Exit(4)
 	}
-	var cfg config.Config
-	err = json.Unmarshal(jsonData, &cfg)
+	err = cfg.OpenArchive()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: Reading Json: %v", err)
+		fmt.Fprintf(os.Stderr, "Error: Reading Archive: %v\n", err)
 		os.Exit(4)
 	}
+	// let webhooks still being sent finish before exiting
+	defer hooks.Wait()
+	switch tools.Argparser(2) {
+	case "verify":
+		verify(cfg, false)
+		return
+	case "repair":
+		verify(cfg, true)
+		return
+	case "assemble":
+		assemble()
+		return
+	case "serve":
+		addr := tools.Argparser(3)
+		if addr == "" {
+			addr = "localhost:8080"
+		}
+		err := serve.Run(addr, ".")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(4)
+		}
+		return
+	case "archive":
+		added, err := cfg.RebuildArchive()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Rebuilding Archive: %v\n", err)
+			os.Exit(4)
+		}
+		fmt.Printf("Added %d completed videos to the archive\n", added)
+		return
+	case "crawl":
+		crawl(cfg)
+		return
+	case "watch":
+		watch(cfg)
+		return
+	case "daemon":
+		runDaemon(&cfg)
+		return
+	}
+	_, dry := tools.Option("dry-run")
+	if _, cached := tools.Option("cached"); dry && cached && tools.Argparser(2) != "playlist" {
+		dryRunCached(cfg, nil)
+		return
+	}
 	if cfg.Empty() {
 		fmt.Println("please modify config.json")
 		return
@@ -211,22 +881,21 @@ func main() {
 				fmt.Println(err)
 				os.Exit(4)
 			}
+			if dry {
+				dryRunCached(cfg, []string{tools.Argparser(3)})
+				return
+			}
 			downloadConent(cfg)
+		} else if dry {
+			dryRun(cfg)
 		} else {
 			downloadPlaylist(cfg)
 		}
-	case "series":
-		serialService(cfg)
-	case "hybrid":
-		hybridService(cfg)
-	//case "parse":
-	//	err := config.ParseHtml(tools.Argparser(3))
-	//	if err != nil {
-	//		fmt.Println(err)
-	//	} else {
-	//		fmt.Println("Parsed HTML Successfully")
-	//	}
 	default:
-		parallelService(cfg)
+		if dry {
+			dryRun(cfg)
+			return
+		}
+		run(cfg, tools.Argparser(2), nil)
 	}
 }
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Defines a counter or gauge, every combination of label values is its own series
+type Metric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mtx    sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+var registry []*Metric
+
+// Metrics exposed on /metrics
+var (
+	BytesDownloaded = newMetric("recu_downloaded_bytes_total", "Bytes of segments downloaded.", "counter")
+	Segments        = newMetric("recu_segments_total", "Segments downloaded.", "counter")
+	Retries         = newMetric("recu_retries_total", "Failed requests that were retried, by status code.", "counter", "status")
+	ActiveJobs      = newMetric("recu_active_jobs", "Downloads running.", "gauge")
+	ServerBytes     = newMetric("recu_server_downloaded_bytes_total", "Bytes of segments downloaded from each CDN server.", "counter", "server")
+	Resolves        = newMetric("recu_resolves_total", "Playlists resolved, by outcome.", "counter", "outcome")
+	DailyLimit      = newMetric("recu_daily_limit_reached", "1 if the last resolve failed because the daily views were used.", "gauge")
+)
+
+func newMetric(name, help, kind string, labels ...string) *Metric {
+	metric := &Metric{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]float64),
+		keys:   make(map[string][]string),
+	}
+	registry = append(registry, metric)
+	return metric
+}
+
+// Adds to the series of the label values
+func (metric *Metric) Add(value float64, labelValues ...string) {
+	metric.mtx.Lock()
+	defer metric.mtx.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	metric.values[key] += value
+	metric.keys[key] = labelValues
+}
+
+// Sets the series of the label values
+func (metric *Metric) Set(value float64, labelValues ...string) {
+	metric.mtx.Lock()
+	defer metric.mtx.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	metric.values[key] = value
+	metric.keys[key] = labelValues
+}
+
+// Returns the label of a retry with the status code, 429 and 410 have their own
+func RetryStatus(status int) string {
+	switch status {
+	case 429, 410:
+		return strconv.Itoa(status)
+	}
+	return "other"
+}
+
+// Writes every metric in the Prometheus text exposition format
+func Write(w io.Writer) {
+	for _, metric := range registry {
+		metric.write(w)
+	}
+}
+
+// Writes the metric, a metric without labels is always written
+func (metric *Metric) write(w io.Writer) {
+	metric.mtx.Lock()
+	defer metric.mtx.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+	if len(metric.labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", metric.name, formatFloat(metric.values[""]))
+		return
+	}
+	keys := make([]string, 0, len(metric.values))
+	for key := range metric.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pairs := make([]string, len(metric.labels))
+		for i, label := range metric.labels {
+			var value string
+			if i < len(metric.keys[key]) {
+				value = metric.keys[key][i]
+			}
+			pairs[i] = fmt.Sprintf("%s=%q", label, value)
+		}
+		fmt.Fprintf(w, "%s{%s} %s\n", metric.name, strings.Join(pairs, ","), formatFloat(metric.values[key]))
+	}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Serves the metrics
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	Write(w)
+}
+
+// Serves the metrics on /metrics of the address
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", Handler)
+	fmt.Printf("Serving metrics on http://%v/metrics\n", addr)
+	return http.ListenAndServe(addr, mux)
+}
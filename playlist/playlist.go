@@ -2,41 +2,68 @@ package playlist
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Playlist struct {
-	JsonLoc  int
-	M3u8     []byte
-	List     []string
-	Filename string
+	JsonLoc   int
+	M3u8      []byte
+	List      []string
+	Durations []float64
+	Filename  string
+	Performer string
+	Date      time.Time
+	Duration  time.Duration
+	VideoId   string
+	Quality   string
+	Bandwidth int
+	Clip      string
+	Site      string
 }
 
 func New(raw_m3u8 []byte, url string, jsonLoc int) (playList Playlist, err error) {
-	filename, err := parsePlaylistUrl(url)
+	filename, performer, date, err := parsePlaylistUrl(url)
 	if err != nil {
 		return playList, err
 	}
 	playList = NewFromFilename(raw_m3u8, filename, jsonLoc)
+	playList.Performer = performer
+	playList.Date = date
 	return
 }
 func NewFromFilename(raw_m3u8 []byte, filename string, jsonLoc int) (playList Playlist) {
 	playlistLines := strings.Split(string(raw_m3u8), "\n")
 	list := make([]string, 0, len(playlistLines)/2)
+	durations := make([]float64, 0, len(playlistLines)/2)
+	var duration, extinf float64
 	for _, line := range playlistLines {
+		// sum the segment durations
+		if strings.HasPrefix(line, "#EXTINF:") {
+			secs, err := strconv.ParseFloat(strings.Split(strings.TrimSpace(line[8:]), ",")[0], 64)
+			if err == nil {
+				duration += secs
+				extinf = secs
+			}
+		}
 		if len(line) < 2 || line[0] == '#' {
 			continue
 		}
 		list = append(list, line)
+		durations = append(durations, extinf)
 	}
 	if len(list) > 0 {
 		list = list[1 : len(list)-1]
+		durations = durations[1 : len(durations)-1]
 	}
 	playList = Playlist{
-		JsonLoc:  jsonLoc,
-		M3u8:     raw_m3u8,
-		List:     list,
-		Filename: filename,
+		JsonLoc:   jsonLoc,
+		M3u8:      raw_m3u8,
+		List:      list,
+		Durations: durations,
+		Filename:  filename,
+		Duration:  time.Duration(duration * float64(time.Second)),
 	}
 	return
 }
@@ -69,22 +96,24 @@ func (p *Playlist) PlaylistOrigin() (domain string, err error) {
 	return
 }
 
-// creates the filename from a given m3u8 url
-func parsePlaylistUrl(url string) (filename string, err error) {
+// creates the filename, performer and broadcast date from a given m3u8 url
+func parsePlaylistUrl(url string) (filename string, performer string, date time.Time, err error) {
 	urlSplit := strings.Split(url, "/")
 	if len(urlSplit) < 6 {
-		return "", fmt.Errorf("wrong url format")
+		err = fmt.Errorf("wrong url format")
+		return
 	}
 	// parse username and date
-	username := urlSplit[4]
-	date := strings.ReplaceAll(urlSplit[5], ",", "-")
-	dateSplit := strings.Split(date, "-")
+	performer = urlSplit[4]
+	dateSplit := strings.Split(strings.ReplaceAll(urlSplit[5], ",", "-"), "-")
 	if len(dateSplit) < 5 {
-		return "", fmt.Errorf("wrong date format")
+		err = fmt.Errorf("wrong date format")
+		return
 	}
 	if len(dateSplit[0]) == 4 {
 		dateSplit[0] = dateSplit[0][2:]
 	}
-	filename = fmt.Sprintf("CB_%s_%s-%s-%s_%s-%s", username, dateSplit[0], dateSplit[1], dateSplit[2], dateSplit[3], dateSplit[4])
+	filename = fmt.Sprintf("CB_%s_%s-%s-%s_%s-%s", performer, dateSplit[0], dateSplit[1], dateSplit[2], dateSplit[3], dateSplit[4])
+	date, _ = time.Parse("06-01-02-15-04", strings.Join(dateSplit[:5], "-"))
 	return
 }
@@ -0,0 +1,116 @@
+package playlist
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// matches the fields of a template such as {performer} or {date:2006-01-02}
+var fieldRegex = regexp.MustCompile(`\{([a-z_]+)(?::([^}]*))?\}`)
+
+// characters that are invalid in filenames on Windows or Linux
+var invalidRegex = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
+
+// names reserved by Windows
+var reservedRegex = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[0-9]|lpt[0-9])(\..*)?$`)
+
+var fields = map[string]bool{
+	"performer": true, "date": true, "time": true, "video_id": true, "quality": true,
+	"clip": true, "site": true, "yyyy": true, "mm": true, "dd": true,
+}
+
+// Checks that the template only uses known fields
+func CheckTemplate(template string) error {
+	for _, match := range fieldRegex.FindAllStringSubmatch(template, -1) {
+		if !fields[match[1]] {
+			return fmt.Errorf("unknown field {%s} in %q", match[1], template)
+		}
+	}
+	return nil
+}
+
+// Returns the template with its fields filled in from the playlist, each field is sanitized
+// so it can not add directories, fields are {performer}, {date:<layout>}, {time}, {video_id},
+// {quality}, {clip}, {site}, {yyyy}, {mm} and {dd}
+func (p *Playlist) Format(template string) string {
+	return fieldRegex.ReplaceAllStringFunc(template, func(field string) string {
+		match := fieldRegex.FindStringSubmatch(field)
+		var value string
+		switch match[1] {
+		case "performer":
+			value = p.Performer
+		case "date":
+			layout := match[2]
+			if layout == "" {
+				layout = "06-01-02"
+			}
+			value = p.Date.Format(layout)
+		case "time":
+			value = p.Date.Format("15-04")
+		case "video_id":
+			value = p.VideoId
+		case "quality":
+			value = p.Quality
+		case "clip":
+			value = p.Clip
+		case "site":
+			value = p.Site
+		case "yyyy":
+			value = p.Date.Format("2006")
+		case "mm":
+			value = p.Date.Format("01")
+		case "dd":
+			value = p.Date.Format("02")
+		default:
+			return field
+		}
+		return invalidRegex.ReplaceAllString(value, "_")
+	})
+}
+
+// Sets the filename from the templates of the filename and its directory,
+// the filename is kept if the playlist lacks the date the templates need
+func (p *Playlist) ApplyTemplate(filename, directory string) {
+	if p.Date.IsZero() {
+		return
+	}
+	name := Sanitize(p.Filename)
+	if filename != "" {
+		if formated := Sanitize(p.Format(filename)); formated != "" {
+			name = formated
+		}
+	}
+	var parts []string
+	separator := func(r rune) bool {
+		return r == '/' || r == '\\'
+	}
+	for _, part := range strings.FieldsFunc(p.Format(directory), separator) {
+		if part = Sanitize(part); part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+	p.Filename = filepath.Join(append(parts, name)...)
+}
+
+// Returns the name with the characters and names that are invalid on Windows or Linux replaced
+func Sanitize(name string) string {
+	name = invalidRegex.ReplaceAllString(name, "_")
+	// Windows drops trailing dots and spaces
+	name = strings.TrimRight(name, ". ")
+	if reservedRegex.MatchString(name) {
+		name = "_" + name
+	}
+	return name
+}
+
+// Creates the directory the playlist's files are saved in
+func (p *Playlist) MakeDir() error {
+	dir := filepath.Dir(p.Filename)
+	if dir == "." {
+		return nil
+	}
+	return os.MkdirAll(dir, 0777)
+}
@@ -0,0 +1,139 @@
+package recu
+
+import (
+	"recurbate/tools"
+	"sync"
+	"time"
+)
+
+// Defines a handle to pause, resume and cancel a running Mux, a nil Control is never paused
+type Control struct {
+	mtx       sync.Mutex
+	paused    bool
+	cancelled bool
+}
+
+// Pauses Mux before its next segment request, the output stays open
+func (control *Control) Pause() {
+	control.mtx.Lock()
+	defer control.mtx.Unlock()
+	control.paused = true
+}
+
+// Resumes a paused Mux at the segment it stopped at
+func (control *Control) Resume() {
+	control.mtx.Lock()
+	defer control.mtx.Unlock()
+	control.paused = false
+}
+
+// Stops Mux before its next segment, it returns the index to resume at
+func (control *Control) Cancel() {
+	control.mtx.Lock()
+	defer control.mtx.Unlock()
+	control.cancelled = true
+}
+
+// Returns whether the Control is paused
+func (control *Control) Paused() bool {
+	if control == nil {
+		return false
+	}
+	control.mtx.Lock()
+	defer control.mtx.Unlock()
+	return control.paused
+}
+
+// Returns whether the Control was cancelled
+func (control *Control) Cancelled() bool {
+	if control == nil {
+		return false
+	}
+	control.mtx.Lock()
+	defer control.mtx.Unlock()
+	return control.cancelled
+}
+
+// Blocks while it or every download is paused, returns true once cancelled
+func (control *Control) wait() bool {
+	if control == nil {
+		return false
+	}
+	for (control.Paused() || all.Paused()) && !control.Cancelled() && !tools.Abort {
+		time.Sleep(200 * time.Millisecond)
+	}
+	return control.Cancelled()
+}
+
+// Defines the progress of a running Mux
+type Progress struct {
+	// segment index last written, and the index the download ends at
+	Index int `json:"index"`
+	End   int `json:"end"`
+	// percent of the whole playlist
+	Percent float64 `json:"percent"`
+	// bytes per second
+	Speed     float64       `json:"speed"`
+	Remaining time.Duration `json:"remaining"`
+	Bytes     int64         `json:"bytes"`
+}
+
+// pauses every download, used by signals and the keyboard
+var all = &Control{}
+
+// controls of the running downloads by the id of their progress line
+var running struct {
+	mtx      sync.Mutex
+	controls map[int]*Control
+}
+
+// Pauses every download before its next segment
+func PauseAll() {
+	all.Pause()
+}
+
+// Resumes every download paused with PauseAll, downloads paused on their own stay paused
+func ResumeAll() {
+	all.Resume()
+}
+
+// Pauses the download with the id of its progress line, returns false if it is not running
+func PauseJob(id int) bool {
+	control := job(id)
+	if control == nil {
+		return false
+	}
+	control.Pause()
+	return true
+}
+
+// Resumes the download with the id of its progress line, returns false if it is not running
+func ResumeJob(id int) bool {
+	control := job(id)
+	if control == nil {
+		return false
+	}
+	control.Resume()
+	return true
+}
+
+func job(id int) *Control {
+	running.mtx.Lock()
+	defer running.mtx.Unlock()
+	return running.controls[id]
+}
+
+// Registers the control of a running download under the id of its progress line
+func register(id int, control *Control) (unregister func()) {
+	running.mtx.Lock()
+	defer running.mtx.Unlock()
+	if running.controls == nil {
+		running.controls = make(map[int]*Control)
+	}
+	running.controls[id] = control
+	return func() {
+		running.mtx.Lock()
+		defer running.mtx.Unlock()
+		delete(running.controls, id)
+	}
+}
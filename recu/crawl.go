@@ -0,0 +1,159 @@
+package recu
+
+import (
+	"fmt"
+	"net/url"
+	"recurbate/tools"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defines a video found on a performer page
+type Listing struct {
+	Url       string `json:"-"`
+	Id        string `json:"id"`
+	Performer string `json:"performer,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Duration  string `json:"duration,omitempty"`
+}
+
+var (
+	linkRegex     = regexp.MustCompile(`href="(/(?:([^"/]+)/)?video/(\d+)(?:/play)?)/?"`)
+	isoDateRegex  = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})(?:\b|T)`)
+	textDateRegex = regexp.MustCompile(`\b(Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec)[a-z]* (\d{1,2}),? (\d{4})\b`)
+	durationRegex = regexp.MustCompile(`\b(?:(\d{1,2}):)?(\d{1,2}):(\d{2})\b`)
+	// a date directly before a time, the time is the time of day and not a duration
+	dateBeforeRegex = regexp.MustCompile(`(?:\b\d{4}-\d{2}-\d{2}|\b(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec)[a-z]* \d{1,2},? \d{4}),?\s*(?:at\s+)?$`)
+)
+
+// Crawls a performer page, following pagination, and returns every video listed
+func Crawl(performerUrl string, header map[string]string, maxPages int) (listings []Listing, err error) {
+	page, err := url.Parse(performerUrl)
+	if err != nil {
+		return
+	}
+	origin := page.Scheme + "://" + page.Host
+	seen := make(map[string]bool)
+	for n := 1; maxPages <= 0 || n <= maxPages; n++ {
+		if tools.Abort {
+			break
+		}
+		query := page.Query()
+		query.Set("page", strconv.Itoa(n))
+		page.RawQuery = query.Encode()
+		tools.Status("Crawling Page: %d", n)
+		data, status, err := tools.Request(page.String(), 10, tools.FormatedHeader(header, "", 1), nil, "GET")
+		if err != nil {
+			tools.StatusEnd()
+			return listings, err
+		}
+		if status != 200 {
+			// pages past the end may return 404
+			if n > 1 && status == 404 {
+				break
+			}
+			tools.StatusEnd()
+			return listings, fmt.Errorf("status code: %d, %s", status, tools.ANSIColor(tools.ShortenString(string(data), 200), 2))
+		}
+		found := ParseListings(string(data), origin)
+		added := 0
+		for _, listing := range found {
+			if seen[listing.Id] {
+				continue
+			}
+			seen[listing.Id] = true
+			listings = append(listings, listing)
+			added++
+		}
+		// stop once a page has nothing new or does not link to the next page
+		if added == 0 || !strings.Contains(string(data), fmt.Sprintf("page=%d", n+1)) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	tools.StatusLine("Crawling: Complete, found %d videos", len(listings))
+	return
+}
+
+// Parses video links, dates and durations from a performer page
+func ParseListings(html string, origin string) (listings []Listing) {
+	matches := linkRegex.FindAllStringSubmatchIndex(html, -1)
+	for i, match := range matches {
+		id := html[match[6]:match[7]]
+		// a video is often linked several times in a row, merge them
+		if len(listings) > 0 && listings[len(listings)-1].Id == id {
+			continue
+		}
+		// the url is the link of the site, the video is downloaded from its play page
+		path := html[match[2]:match[3]]
+		if !strings.HasSuffix(path, "/play") {
+			path += "/play"
+		}
+		listing := Listing{
+			Url: origin + path,
+			Id:  id,
+		}
+		if match[4] != -1 {
+			listing.Performer = html[match[4]:match[5]]
+		}
+		// the details of the video lie between this link and the next one
+		end := len(html)
+		for _, next := range matches[i+1:] {
+			if html[next[6]:next[7]] != id {
+				end = next[0]
+				break
+			}
+		}
+		block := html[match[1]:end]
+		listing.Date = parseDate(block)
+		listing.Duration = parseDuration(block)
+		listings = append(listings, listing)
+	}
+	return
+}
+
+// Returns the first date in the block formated as 2006-01-02
+func parseDate(block string) string {
+	if date := isoDateRegex.FindStringSubmatch(block); date != nil {
+		return date[1] + "-" + date[2] + "-" + date[3]
+	}
+	date := textDateRegex.FindStringSubmatch(block)
+	if date == nil {
+		return ""
+	}
+	parsed, err := time.Parse("Jan 2 2006", fmt.Sprintf("%s %s %s", date[1], date[2], date[3]))
+	if err != nil {
+		return ""
+	}
+	return parsed.Format("2006-01-02")
+}
+
+// Returns the first duration in the block formated as h:mm:ss, times following a date are skipped
+func parseDuration(block string) string {
+	for _, match := range durationRegex.FindAllStringSubmatchIndex(block, -1) {
+		// the end of a longer time such as 23:59:59 or a time of day
+		if strings.HasSuffix(block[:match[0]], ":") || dateBeforeRegex.MatchString(block[:match[0]]) {
+			continue
+		}
+		var hours int
+		if match[2] != -1 {
+			hours, _ = strconv.Atoi(block[match[2]:match[3]])
+		}
+		minutes, _ := strconv.Atoi(block[match[4]:match[5]])
+		return fmt.Sprintf("%d:%02d:%s", hours, minutes, block[match[6]:match[7]])
+	}
+	return ""
+}
+
+// Returns the video id of a recu video url
+func VideoId(videoUrl string) string {
+	split := strings.Split(videoUrl, "/")
+	for i, v := range split[:len(split)-1] {
+		if v == "video" {
+			return split[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package recu
+
+import (
+	"testing"
+)
+
+// a performer page listing, each video is linked from its thumbnail and its title
+const listingsPage = `<div class="videos">
+<div class="video">
+	<a href="/alice/video/101/play"><img src="/thumb/101.jpg"></a>
+	<a href="/alice/video/101/play">alice</a>
+	<span>2026-09-01 14:32</span><span>45:10</span>
+</div>
+<div class="video">
+	<a href="/alice/video/102/play"><img src="/thumb/102.jpg"></a>
+	<span>Sep 2, 2026 at 9:05</span><span>1:02:03</span>
+</div>
+<div class="video">
+	<a href="/alice/video/103/play"><img src="/thumb/103.jpg"></a>
+	<span>2026-09-03T23:59:59</span><span>12:07</span>
+</div>
+<div class="video">
+	<a href="/video/104/play"></a>
+	<span>Sep 4 2026</span><span>3:04:05</span>
+</div>
+<div class="video">
+	<a href="/alice/video/105/play"></a>
+	<span>2026-09-05 10:00</span>
+</div>
+<div class="video">
+	<a href="/alice/video/106"></a>
+	<span>1500 views</span><span>7:08</span>
+</div>
+</div>
+<a href="?page=2">next</a>`
+
+func TestParseListings(t *testing.T) {
+	want := []Listing{
+		{Url: "https://recu.me/alice/video/101/play", Id: "101", Performer: "alice", Date: "2026-09-01", Duration: "0:45:10"},
+		{Url: "https://recu.me/alice/video/102/play", Id: "102", Performer: "alice", Date: "2026-09-02", Duration: "1:02:03"},
+		{Url: "https://recu.me/alice/video/103/play", Id: "103", Performer: "alice", Date: "2026-09-03", Duration: "0:12:07"},
+		{Url: "https://recu.me/video/104/play", Id: "104", Date: "2026-09-04", Duration: "3:04:05"},
+		{Url: "https://recu.me/alice/video/105/play", Id: "105", Performer: "alice", Date: "2026-09-05"},
+		{Url: "https://recu.me/alice/video/106/play", Id: "106", Performer: "alice", Duration: "0:07:08"},
+	}
+	got := ParseListings(listingsPage, "https://recu.me")
+	if len(got) != len(want) {
+		t.Fatalf("got %d listings, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("listing %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		block string
+		want  string
+	}{
+		{`<span>45:10</span>`, "0:45:10"},
+		{`<span>1:02:03</span>`, "1:02:03"},
+		{`<span>2026-09-01 14:32</span><span>45:10</span>`, "0:45:10"},
+		{`<span>2026-09-01 14:32:10</span><span>45:10</span>`, "0:45:10"},
+		{`<span>Sep 1, 2026 14:32</span><span>45:10</span>`, "0:45:10"},
+		{`<time>2026-09-01T14:32:10</time><span>45:10</span>`, "0:45:10"},
+		{`<span>2026-09-01 14:32</span>`, ""},
+		{`<span>1500 45:10</span>`, "0:45:10"},
+		{`<span>no duration</span>`, ""},
+	}
+	for _, test := range tests {
+		got := parseDuration(test.block)
+		if got != test.want {
+			t.Errorf("parseDuration(%q) = %q, want %q", test.block, got, test.want)
+		}
+	}
+}
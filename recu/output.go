@@ -0,0 +1,433 @@
+package recu
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"recurbate/playlist"
+	"recurbate/ts"
+	"sort"
+	"strings"
+)
+
+// Defines where Mux writes the downloaded segments
+type output interface {
+	// writes the segment at the index of the playlist
+	Write(index int, data []byte) error
+	// closes the output, complete is set once every segment has been written
+	Close(complete bool) error
+	// returns the final path of the output
+	Path() string
+}
+
+// Applies the existing output policy to the filename with the suffix, returns whether to
+// skip the video, renames the playlist if needed, otherwise the output is replaced
+func checkExisting(playList *playlist.Playlist, suffix string, existing string, verify func(path string) error) (skip bool) {
+	path := playList.Filename + suffix
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	switch existing {
+	case "skip":
+		fmt.Printf("Skipped: %v already exists\n", path)
+		return true
+	case "verify":
+		err := verify(path)
+		if err == nil {
+			fmt.Printf("Skipped: %v is already complete\n", path)
+			return true
+		}
+		fmt.Fprintf(os.Stderr, "%v is incomplete, downloading again: %v\n", path, err)
+		return
+	case "overwrite":
+		return
+	}
+	for i := 1; i > 0; i++ {
+		new := fmt.Sprintf("%s(%d)", playList.Filename, i)
+		_, err := os.Stat(new + suffix)
+		if err != nil {
+			playList.Filename = new
+			break
+		}
+	}
+	return
+}
+
+// Returns where the file at the final path is written while downloading, in the
+// incomplete directory or with a .part suffix
+func (opts Options) workPath(final string) string {
+	if opts.Incomplete != "" {
+		rel, err := filepath.Rel(filepath.Join(".", opts.Complete), final)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = final
+		}
+		return filepath.Join(opts.Incomplete, rel)
+	}
+	if opts.Part {
+		return final + ".part"
+	}
+	return final
+}
+
+// Moves the file at the work path to its final path
+func finalize(work, final string) error {
+	if work == final {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(final), 0777)
+	if err != nil {
+		return err
+	}
+	return os.Rename(work, final)
+}
+
+// Defines the single .ts file output along with its segment index
+type fileOutput struct {
+	file      *os.File
+	index     *os.File
+	offset    int64
+	durations []float64
+	// final paths of the .ts file and its index
+	final      string
+	finalIndex string
+}
+
+// Opens the .ts file, appending to it if restarted, returns a nil output if the video is skipped
+func openFile(playList *playlist.Playlist, restarted bool, segments int, opts Options) (output, error) {
+	var file *os.File
+	var err error
+	// checks if continuation of previous run
+	if restarted {
+		file, err = os.OpenFile(opts.workPath(playList.Filename+".ts"), os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "oringal file not found, creating new one: %v", err)
+		}
+	}
+	indexFlag := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+	// creates file
+	if file == nil {
+		indexFlag |= os.O_TRUNC
+		flag := os.O_WRONLY | os.O_APPEND | os.O_CREATE | os.O_TRUNC
+		skip := checkExisting(playList, ".ts", opts.Existing, func(path string) error {
+			return ts.VerifyFile(path, segments)
+		})
+		if skip {
+			return nil, nil
+		}
+		work := opts.workPath(playList.Filename + ".ts")
+		err = os.MkdirAll(filepath.Dir(work), 0777)
+		if err != nil {
+			return nil, err
+		}
+		file, err = os.OpenFile(work, flag, 0666)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// records where each segment is written
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	finalIndex := ts.IndexPath(playList.Filename + ".ts")
+	index, err := os.OpenFile(opts.workPath(finalIndex), indexFlag, 0666)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("can not create segment index: %v", err)
+	}
+	// the archive can be rebuilt with the video id of a new index
+	if indexFlag&os.O_TRUNC != 0 && playList.VideoId != "" {
+		err = ts.WriteIndexVideo(index, playList.VideoId)
+		if err != nil {
+			file.Close()
+			index.Close()
+			return nil, fmt.Errorf("can not write segment index: %v", err)
+		}
+	}
+	return &fileOutput{
+		file:       file,
+		index:      index,
+		offset:     info.Size(),
+		durations:  playList.Durations,
+		final:      playList.Filename + ".ts",
+		finalIndex: finalIndex,
+	}, nil
+}
+
+func (out *fileOutput) Write(index int, data []byte) error {
+	_, err := out.file.Write(data)
+	if err != nil {
+		return err
+	}
+	segment := ts.Segment{Index: index, Offset: out.offset, Size: int64(len(data))}
+	if index < len(out.durations) {
+		segment.Duration = out.durations[index]
+	}
+	err = ts.WriteIndex(out.index, segment)
+	if err != nil {
+		return fmt.Errorf("can not write segment index: %v", err)
+	}
+	out.offset += int64(len(data))
+	return nil
+}
+
+func (out *fileOutput) Path() string {
+	return out.final
+}
+
+// Closes the file, once complete it is synced and moved with its index to the final path
+func (out *fileOutput) Close(complete bool) error {
+	if !complete {
+		out.index.Close()
+		return out.file.Close()
+	}
+	err := ts.EndIndex(out.index)
+	if err != nil {
+		return err
+	}
+	err = out.file.Sync()
+	if err != nil {
+		return err
+	}
+	out.index.Close()
+	err = out.file.Close()
+	if err != nil {
+		return err
+	}
+	err = finalize(out.file.Name(), out.final)
+	if err != nil {
+		return err
+	}
+	return finalize(out.index.Name(), out.finalIndex)
+}
+
+// Defines an output writing the segments in order to a stream such as stdout
+type streamOutput struct {
+	w io.Writer
+}
+
+func (out *streamOutput) Write(index int, data []byte) error {
+	_, err := out.w.Write(data)
+	return err
+}
+
+func (out *streamOutput) Close(complete bool) error {
+	return nil
+}
+
+func (out *streamOutput) Path() string {
+	return "-"
+}
+
+// name of the local playlist in a segment store
+const StorePlaylist = "index.m3u8"
+
+// Returns the filename of the segment at the index in a segment store
+func SegmentName(index int) string {
+	return fmt.Sprintf("%05d.ts", index)
+}
+
+// Defines the segment store output, a directory holding every segment as its own file
+// and a local playlist of the segments written so far
+type storeOutput struct {
+	dir       string
+	final     string
+	durations []float64
+	target    int
+	written   []int
+}
+
+// Opens the segment store of the playlist, returns a nil output if the video is skipped
+func openStore(playList *playlist.Playlist, restarted bool, start, end int, opts Options) (output, error) {
+	if !restarted {
+		skip := checkExisting(playList, "", opts.Existing, func(path string) error {
+			return verifyStore(path, start, end)
+		})
+		if skip {
+			return nil, nil
+		}
+		err := os.RemoveAll(opts.workPath(playList.Filename))
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := &storeOutput{
+		dir:       opts.workPath(playList.Filename),
+		final:     playList.Filename,
+		durations: playList.Durations,
+		target:    1,
+	}
+	err := os.MkdirAll(out.dir, 0777)
+	if err != nil {
+		return nil, err
+	}
+	// the target duration may not change while the playlist is played
+	for _, duration := range playList.Durations {
+		if int(math.Ceil(duration)) > out.target {
+			out.target = int(math.Ceil(duration))
+		}
+	}
+	// segments of a previous run stay in the playlist
+	for i := range playList.List {
+		if _, err := os.Stat(filepath.Join(out.dir, SegmentName(i))); err == nil {
+			out.written = append(out.written, i)
+		}
+	}
+	return out, out.writePlaylist(false)
+}
+
+func (out *storeOutput) Write(index int, data []byte) error {
+	path := filepath.Join(out.dir, SegmentName(index))
+	// the segment only appears once it is complete
+	err := os.WriteFile(path+".part", data, 0666)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path+".part", path)
+	if err != nil {
+		return err
+	}
+	i := sort.SearchInts(out.written, index)
+	if i == len(out.written) || out.written[i] != index {
+		out.written = append(out.written, 0)
+		copy(out.written[i+1:], out.written[i:])
+		out.written[i] = index
+	}
+	return out.writePlaylist(false)
+}
+
+func (out *storeOutput) Path() string {
+	return out.final
+}
+
+// Ends the local playlist once complete and moves the store to its final path
+func (out *storeOutput) Close(complete bool) error {
+	err := out.writePlaylist(complete)
+	if err != nil || !complete || out.dir == out.final {
+		return err
+	}
+	// replaces the store being verified or overwritten
+	err = os.RemoveAll(out.final)
+	if err != nil {
+		return err
+	}
+	return finalize(out.dir, out.final)
+}
+
+// Writes the local playlist, it is only ended once the download is complete
+func (out *storeOutput) writePlaylist(complete bool) error {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n", out.target)
+	for i, index := range out.written {
+		if i > 0 && out.written[i-1] != index-1 {
+			builder.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		var duration float64
+		if index < len(out.durations) {
+			duration = out.durations[index]
+		}
+		fmt.Fprintf(&builder, "#EXTINF:%.3f,\n%s\n", duration, SegmentName(index))
+	}
+	if complete {
+		builder.WriteString("#EXT-X-ENDLIST\n")
+	}
+	path := filepath.Join(out.dir, StorePlaylist)
+	err := os.WriteFile(path+".part", []byte(builder.String()), 0666)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".part", path)
+}
+
+// Checks that every segment between start and end is in the store
+func verifyStore(dir string, start, end int) error {
+	for i := start; i < end; i++ {
+		data, err := os.ReadFile(filepath.Join(dir, SegmentName(i)))
+		if err != nil {
+			return fmt.Errorf("segment %d is missing", i)
+		}
+		err = ts.CheckPackets(data)
+		if err != nil {
+			return fmt.Errorf("segment %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Concatenates the segments of a store into a single .ts file, converting it to MP4 with ffmpeg if mp4 is set
+func Assemble(dir string, mp4 bool) (path string, err error) {
+	dir = filepath.Clean(dir)
+	data, err := os.ReadFile(filepath.Join(dir, StorePlaylist))
+	if err != nil {
+		return
+	}
+	if !strings.Contains(string(data), "#EXT-X-ENDLIST") {
+		fmt.Fprintf(os.Stderr, "%v is not complete, assembling the segments downloaded so far\n", dir)
+	}
+	path = dir + ".ts"
+	file, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	index, err := os.Create(ts.IndexPath(path))
+	if err != nil {
+		return
+	}
+	defer index.Close()
+	var offset int64
+	var extinf float64
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "#EXTINF:") {
+			fmt.Sscanf(line, "#EXTINF:%f,", &extinf)
+		}
+		if len(line) < 2 || line[0] == '#' {
+			continue
+		}
+		var segment int
+		_, err = fmt.Sscanf(line, "%d.ts", &segment)
+		if err != nil {
+			return path, fmt.Errorf("unknown segment %v in playlist", line)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, line))
+		if err != nil {
+			return path, err
+		}
+		_, err = file.Write(data)
+		if err != nil {
+			return path, err
+		}
+		err = ts.WriteIndex(index, ts.Segment{Index: segment, Offset: offset, Size: int64(len(data)), Duration: extinf})
+		if err != nil {
+			return path, err
+		}
+		offset += int64(len(data))
+	}
+	if strings.Contains(string(data), "#EXT-X-ENDLIST") {
+		err = ts.EndIndex(index)
+		if err != nil {
+			return
+		}
+	}
+	err = file.Close()
+	if err != nil || !mp4 {
+		return
+	}
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return path, fmt.Errorf("ffmpeg is needed to create an MP4: %v", err)
+	}
+	mp4Path := dir + ".mp4"
+	cmd := exec.Command(ffmpeg, "-y", "-loglevel", "error", "-i", path, "-c", "copy", mp4Path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return path, fmt.Errorf("ffmpeg: %v", err)
+	}
+	return mp4Path, nil
+}
@@ -2,9 +2,16 @@ package recu
 
 import (
 	"fmt"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"recurbate/metrics"
 	"recurbate/playlist"
 	"recurbate/tools"
+	"recurbate/ts"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -20,7 +27,12 @@ func Parse(siteUrl string, header map[string]string, jsonLoc int) (playList play
 			if err == nil && status == 200 {
 				break
 			}
-			fmt.Printf("Failed Retrying...\033[18D")
+			if !tools.Plain {
+				fmt.Printf("Failed Retrying...\033[18D")
+			}
+			tools.Emit(tools.EventRetry, map[string]any{"url": url, "status": status, "error": fmt.Sprint(err), "attempt": retry + 1})
+			tools.Warn("retry", "url", url, "status", status, "attempt", retry+1, "error", err)
+			metrics.Retries.Add(1, metrics.RetryStatus(status))
 			if retry > 5 {
 				if err == nil {
 					err = fmt.Errorf("%s, status code: %d", tools.ANSIColor(string(data), 2), status)
@@ -34,14 +46,14 @@ func Parse(siteUrl string, header map[string]string, jsonLoc int) (playList play
 		return
 	}
 	// getting webpage
-	fmt.Printf("\rDownloading HTML: ")
+	tools.Status("Downloading HTML: ")
 	htmldata, err := downloadLoop(siteUrl, 10, tools.FormatedHeader(header, "", 1))
 	if err != nil {
 		errorType = "cloudflare"
 		return
 	}
 	html := string(htmldata)
-	fmt.Printf("\r\033[2KDownloading HTML: Complete\n")
+	tools.StatusLine("Downloading HTML: Complete")
 	// determine unique page token
 	token, err := tools.SearchString(html, `data-token="`, `"`)
 	if err != nil {
@@ -57,7 +69,7 @@ func Parse(siteUrl string, header map[string]string, jsonLoc int) (playList play
 	// parse api url
 	apiUrl := strings.Join(strings.Split(siteUrl, "/")[:3], "/") + "/api/video/" + id + "?token=" + token
 	// request api
-	fmt.Printf("\rGetting Link to Playlist: ")
+	tools.Status("Getting Link to Playlist: ")
 	apidata, err := downloadLoop(apiUrl, 10, tools.FormatedHeader(header, apiUrl, 2))
 	if err != nil {
 		errorType = "panic"
@@ -65,7 +77,7 @@ func Parse(siteUrl string, header map[string]string, jsonLoc int) (playList play
 	}
 	api := string(apidata)
 	// continue based on response from api
-	fmt.Printf("\r\033[2KGetting Link to Playlist: Complete\n")
+	tools.StatusLine("Getting Link to Playlist: Complete")
 	switch api {
 	case "shall_subscribe":
 		errorType = "wait"
@@ -85,7 +97,7 @@ func Parse(siteUrl string, header map[string]string, jsonLoc int) (playList play
 		return
 	}
 	playlistUrl = strings.ReplaceAll(playlistUrl, "amp;", "")
-	fmt.Printf("\rDownloading Playlists: ")
+	tools.Status("Downloading Playlists: ")
 	// get m3u8 playlist
 	playlistData, err := downloadLoop(playlistUrl, 10, tools.FormatedHeader(header, "", 0))
 	if err != nil {
@@ -93,27 +105,32 @@ func Parse(siteUrl string, header map[string]string, jsonLoc int) (playList play
 	}
 	playlistRef := string(playlistData)
 	playlistLines := strings.Split(playlistRef, "\n")
-	fmt.Printf("\r\033[2KDownloading Playlists: Complete\n")
+	tools.StatusLine("Downloading Playlists: Complete")
 	// determine url prefix for playlist entries
 	prefix := playlistUrl[:strings.LastIndex(playlistUrl, "/")+1]
 	// if playlist contains resolution selection
+	var quality string
+	var bandwidth int
 	if strings.Contains(playlistRef, "EXT-X-STREAM-INF") {
 		for i := 0; i < len(playlistLines)-1; i++ {
 			if strings.Contains(playlistLines[i], "NAME=max") {
+				quality = variantQuality(playlistLines[i])
+				bandwidth = variantBandwidth(playlistLines[i])
 				playlistUrl = playlistLines[i+1]
 				if !strings.Contains(playlistUrl, prefix) {
 					playlistUrl = prefix + playlistUrl
 				}
 			}
 		}
-		fmt.Printf("\rDownloading Playlist: ")
+		tools.Emit(tools.EventVariant, map[string]any{"url": siteUrl, "quality": quality, "bandwidth": bandwidth, "playlist": playlistUrl})
+		tools.Status("Downloading Playlist: ")
 		playlistData, err = downloadLoop(playlistUrl, 10, tools.FormatedHeader(header, "", 0))
 		if err != nil {
 			errorType = "panic"
 			return
 		}
 		playlistLines = strings.Split(string(playlistData), "\n")
-		fmt.Printf("\r\033[2KDownloading Playlist: Complete\n")
+		tools.StatusLine("Downloading Playlist: Complete")
 	}
 	// added prefix to playlist
 	for i, line := range playlistLines {
@@ -127,25 +144,131 @@ func Parse(siteUrl string, header map[string]string, jsonLoc int) (playList play
 	playList, err = playlist.New([]byte(strings.Join(playlistLines, "\n")), playlistUrl, jsonLoc)
 	if err != nil {
 		errorType = "panic"
+		return
+	}
+	playList.VideoId = VideoId(siteUrl)
+	playList.Quality = quality
+	playList.Bandwidth = bandwidth
+	if site, err := url.Parse(siteUrl); err == nil {
+		playList.Site = site.Host
 	}
 	return
 }
 
+// Returns the bandwidth in bits per second of a variant from its EXT-X-STREAM-INF line
+func variantBandwidth(line string) int {
+	// AVERAGE-BANDWIDTH also contains BANDWIDTH=
+	line = strings.ReplaceAll(line, "AVERAGE-BANDWIDTH=", "")
+	bandwidth, err := tools.SearchString(line+",", "BANDWIDTH=", ",")
+	if err != nil {
+		return 0
+	}
+	num, _ := strconv.Atoi(bandwidth)
+	return num
+}
+
+// Estimates the size of the segments between start and end from the bandwidth of the
+// variant and the segment durations, or by sampling the Content-Length of a few segments
+func EstimateSize(playList playlist.Playlist, header map[string]string, start, end int) int64 {
+	if end <= start {
+		return 0
+	}
+	if playList.Bandwidth > 0 && len(playList.Durations) >= end {
+		var secs float64
+		for _, duration := range playList.Durations[start:end] {
+			secs += duration
+		}
+		if secs > 0 {
+			return int64(float64(playList.Bandwidth) / 8 * secs)
+		}
+	}
+	var total, sampled int64
+	for _, i := range []int{start, (start + end) / 2, end - 1} {
+		_, status, respHeader, err := tools.RequestHeader(playList.List[i], 10, header, nil, "HEAD")
+		if err != nil || status != 200 {
+			continue
+		}
+		size, err := strconv.ParseInt(respHeader.Get("Content-Length"), 10, 64)
+		if err != nil {
+			continue
+		}
+		total += size
+		sampled++
+	}
+	if sampled == 0 {
+		return 0
+	}
+	return total / sampled * int64(end-start)
+}
+
+// Returns the quality of a variant from its EXT-X-STREAM-INF line, e.g. 1080p
+func variantQuality(line string) string {
+	if resolution, err := tools.SearchString(line+",", "RESOLUTION=", ","); err == nil {
+		if split := strings.Split(resolution, "x"); len(split) == 2 {
+			return split[1] + "p"
+		}
+	}
+	if name, err := tools.SearchString(line+",", "NAME=", ","); err == nil {
+		return strings.Trim(name, `"`)
+	}
+	return ""
+}
+
+// Defines how Mux writes its output
+type Options struct {
+	// what to do when the output already exists: skip, overwrite, verify or rename
+	Existing string
+	// file to mux into a single .ts file or segments to keep every segment in its own file
+	Store string
+	// - to write the segments to stdout instead
+	Target string
+	// directory the output is written to while downloading, it is moved to the
+	// complete directory once finished
+	Incomplete string
+	Complete   string
+	// adds .part to the output while downloading
+	Part bool
+	// bytes to keep free on the output filesystem, downloads are queued or paused below it
+	MinFree int64
+	// pauses and cancels the download, may be nil
+	Control *Control
+	// called after every segment, may be nil
+	Progress func(Progress)
+	// returns the header of the next segment request so a refreshed cookie is used, may be nil
+	Header func() map[string]string
+}
+
+// Defines what Mux has written
+type Stats struct {
+	// final path of the output, - for stdout
+	Path     string
+	Segments int
+	Bytes    int64
+	// seconds of video written
+	Duration float64
+	// the output already existed and was left alone, Verified if it was checked to be complete
+	Skipped  bool
+	Verified bool
+}
+
+// returned by Mux if the download could not be started
+const NotStarted = -1
+
 // Muxes the transport streams and saves it to a file
-func Mux(playList playlist.Playlist, header map[string]string, restartIndex int, durationPercent []float64) int {
+func Mux(playList playlist.Playlist, header map[string]string, restartIndex int, durationPercent []float64, opts Options) (int, Stats) {
+	var stats Stats
 	var data []byte
 	var err error
-	var file *os.File
 	var avgdur, avgsize tools.AvgBuffer
 	if tools.Abort {
-		return 0
+		return NotStarted, stats
 	}
 	restarted := false
 	if restartIndex != 0 {
 		restarted = true
 	}
-	if durationPercent[0] > 100 || durationPercent[1] <= durationPercent[0] {
-		return 0
+	if math.IsNaN(durationPercent[0]) || math.IsNaN(durationPercent[1]) || durationPercent[0] > 100 || durationPercent[1] <= durationPercent[0] {
+		return 0, stats
 	}
 	if durationPercent[0] < 0 {
 		durationPercent[0] = 0
@@ -153,35 +276,6 @@ func Mux(playList playlist.Playlist, header map[string]string, restartIndex int,
 	if durationPercent[1] > 100 {
 		durationPercent[1] = 100
 	}
-	// checks if continuation of previous run
-	if restarted {
-		file, err = os.OpenFile(playList.Filename+".ts", os.O_APPEND|os.O_WRONLY, 0666)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "oringal file not found, creating new one: %v", err)
-		}
-	}
-	// creates file
-	if file == nil {
-		// checks for filename collisions
-		_, err = os.Stat(playList.Filename + ".ts")
-		if err == nil {
-			for i := 1; i > 0; i++ {
-				new := fmt.Sprintf("%s(%d)", playList.Filename, i)
-				_, err := os.Stat(new + ".ts")
-				if err != nil {
-					playList.Filename = new
-					break
-				}
-			}
-		}
-		file, err = os.OpenFile(playList.Filename+".ts", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "can not create file: %v", err)
-			return restartIndex
-		}
-	}
-	defer file.Close()
-	// muxing loop //
 	var startIndex, endIndex int
 	if restarted {
 		startIndex = restartIndex
@@ -189,25 +283,122 @@ func Mux(playList playlist.Playlist, header map[string]string, restartIndex int,
 		startIndex = int(float64(playList.Len()) * durationPercent[0] / 100)
 	}
 	endIndex = int(float64(playList.Len()) * durationPercent[1] / 100)
+	var out output
+	if opts.Target == "-" {
+		out = &streamOutput{w: tools.Stdout}
+	} else if opts.Store == "segments" {
+		out, err = openStore(&playList, restarted, startIndex, endIndex, opts)
+	} else {
+		out, err = openFile(&playList, restarted, endIndex-startIndex, opts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can not create output: %v\n", err)
+		return NotStarted, stats
+	}
+	if out == nil {
+		stats.Skipped = true
+		// with verify the output is only skipped once it is checked to be complete
+		stats.Verified = opts.Existing == "verify"
+		return 0, stats
+	}
+	stats.Path = out.Path()
+	closed := false
+	defer func() {
+		if closed {
+			return
+		}
+		err := out.Close(false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "can not close output: %v\n", err)
+		}
+	}()
+	dir := filepath.Dir(opts.workPath(playList.Filename + ".ts"))
+	// checks the video fits on the output filesystem, only once the output is not skipped.
+	// Bytes still reserved, written segments are taken off as they already lower the free space
+	var reservation int64
+	if opts.Target != "-" {
+		reservation = EstimateSize(playList, header, startIndex, endIndex)
+		err = tools.Reserve(dir, reservation, opts.MinFree)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Not enough disk space for %v: %v\n", playList.Filename, err)
+			return NotStarted, stats
+		}
+		defer func() {
+			tools.Release(reservation)
+		}()
+	}
+	bar := tools.NewBar(filepath.Base(playList.Filename))
+	defer bar.Done()
+	// the download can be paused from the keyboard by the id of its line
+	if opts.Control == nil {
+		opts.Control = &Control{}
+	}
+	defer register(bar.Id(), opts.Control)()
+	var lastEvent time.Time
+	started := time.Now()
+	server := origin(playList)
+	metrics.ActiveJobs.Add(1)
+	defer metrics.ActiveJobs.Add(-1)
+	tools.Info("download started", "filename", playList.Filename, "path", stats.Path, "start", startIndex, "end", endIndex, "resumed", restarted)
+	// muxing loop //
 	for i, tsLink := range playList.List[startIndex:endIndex] {
 		i := i + startIndex
-		if tools.Abort {
-			fmt.Println("\naborting...")
-			return i
+		paused := all.Paused() || opts.Control.Paused()
+		if paused {
+			bar.SetPaused(true)
+			tools.Info("download paused", "filename", playList.Filename, "index", i)
+		}
+		cancelled := opts.Control.wait()
+		if paused && !cancelled && !tools.Abort {
+			bar.SetPaused(false)
+			tools.Info("download resumed", "filename", playList.Filename, "index", i)
+		}
+		if tools.Abort || cancelled {
+			bar.Break()
+			if tools.Abort {
+				fmt.Println("aborting...")
+			} else {
+				fmt.Printf("cancelled %v\n", playList.Filename)
+			}
+			tools.Info("download stopped", "filename", playList.Filename, "index", i, "aborted", tools.Abort)
+			return resumeIndex(i), stats
+		}
+		if opts.Target != "-" {
+			tools.WaitForSpace(dir, opts.MinFree)
 		}
 		startTime := time.Now()
-		err := downloadLoop(&data, tsLink, header, 10, 5)
+		if opts.Header != nil {
+			header = opts.Header()
+		}
+		err := downloadLoop(&data, tsLink, header, 10, 5, bar)
 		if err != nil {
-			fmt.Println()
-			fmt.Fprintf(os.Stderr, "Error: %v\n", tools.ANSIColor(err, 2))
+			bar.Break()
+			fmt.Fprintf(os.Stderr, "Error: segment %d: %v\n", i, tools.ANSIColor(err, 2))
 			fmt.Fprintf(os.Stderr, "Failed at %.2f%%\n", float32(i)/float32(playList.Len())*100)
-			return i
+			tools.Error("download failed", "filename", playList.Filename, "index", i, "error", err)
+			return resumeIndex(i), stats
 		}
 		endDur := time.Since(startTime).Minutes()
-		_, err = file.Write(data)
+		err = out.Write(i, data)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "can not write file: %v", err)
-			return i
+			tools.Error("write failed", "filename", playList.Filename, "index", i, "error", err)
+			return resumeIndex(i), stats
+		}
+		stats.Segments++
+		stats.Bytes += int64(len(data))
+		if written := int64(len(data)); reservation > 0 {
+			if written > reservation {
+				written = reservation
+			}
+			tools.Release(written)
+			reservation -= written
+		}
+		metrics.Segments.Add(1)
+		metrics.BytesDownloaded.Add(float64(len(data)))
+		metrics.ServerBytes.Add(float64(len(data)), server)
+		if i < len(playList.Durations) {
+			stats.Duration += playList.Durations[i]
 		}
 		// Calculate User Interface Timings
 		avgsize.Add(float64(len(data)))
@@ -216,39 +407,106 @@ func Mux(playList playlist.Playlist, header map[string]string, restartIndex int,
 		speedSecs := avgsize.Average() / (getavgdur * 60)
 		eta := getavgdur * ((float64(playList.Len()) * durationPercent[1] / 100) - float64(i))
 		percent := float64(i) / float64(playList.Len()) * 100
-		fmt.Printf("\n\033[A\033[2KDownloading: %s\tRemaining: %s\t%s", tools.ANSIColor(fmt.Sprintf("%.1f%%", percent), 33), tools.FormatMinutes(eta), tools.FormatBytesPerSecond(speedSecs))
+		bar.Update(percent, eta, speedSecs)
+		if time.Since(lastEvent) >= tools.ProgressInterval || i == endIndex-1 {
+			lastEvent = time.Now()
+			tools.Emit(tools.EventProgress, map[string]any{
+				"filename":  playList.Filename,
+				"index":     i,
+				"end":       endIndex,
+				"percent":   percent,
+				"speed":     speedSecs,
+				"remaining": eta * 60,
+				"bytes":     stats.Bytes,
+			})
+		}
+		if opts.Progress != nil {
+			opts.Progress(Progress{
+				Index:     i,
+				End:       endIndex,
+				Percent:   percent,
+				Speed:     speedSecs,
+				Remaining: time.Duration(eta * float64(time.Minute)),
+				Bytes:     stats.Bytes,
+			})
+		}
+	}
+	bar.Break()
+	// resuming at the end only finalizes the output again
+	closed = true
+	err = out.Close(true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can not finalize output: %v\n", err)
+		tools.Error("finalize failed", "filename", playList.Filename, "error", err)
+		return endIndex, stats
 	}
-	fmt.Println()
-	return 0
+	tools.Info("download completed", "filename", playList.Filename, "path", stats.Path, "segments", stats.Segments, "bytes", stats.Bytes, "took", time.Since(started).Round(time.Millisecond))
+	return 0, stats
 }
 
-// download retry loop for Mux()
-func downloadLoop(data *[]byte, url string, header map[string]string, timeout, maxRetry int) (err error) {
+// Returns the CDN server of the playlist, unknown if it has none
+func origin(playList playlist.Playlist) (server string) {
+	defer func() {
+		if recover() != nil {
+			server = "unknown"
+		}
+	}()
+	server, err := playList.PlaylistOrigin()
+	if err != nil {
+		return "unknown"
+	}
+	return
+}
+
+// Returns the index Mux stopped at, 0 would mean the video is complete
+func resumeIndex(i int) int {
+	if i == 0 {
+		return NotStarted
+	}
+	return i
+}
+
+// download retry loop for Mux(), segments served with status 200 that are not valid transport streams are retried
+func downloadLoop(data *[]byte, url string, header map[string]string, timeout, maxRetry int, bar *tools.Bar) (err error) {
 	retry := 0
 	for {
 		var status int
-		*data, status, err = tools.Request(url, timeout, header, nil, "GET")
+		var respHeader http.Header
+		*data, status, respHeader, err = tools.RequestHeader(url, timeout, header, nil, "GET")
 		if err == nil && status == 200 {
-			break
+			err = ts.ValidateSegment(*data, respHeader)
+			if err == nil {
+				break
+			}
+			// show the start of pages served instead of the segment
+			if len(*data) > 0 && (*data)[0] != ts.SyncByte {
+				err = fmt.Errorf("%v, %s", err, tools.ShortenString(string(*data), 100))
+			}
+			err = fmt.Errorf("invalid segment: %v", err)
 		}
 		if status == 429 {
+			metrics.Retries.Add(1, metrics.RetryStatus(status))
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 		if status == 410 {
+			tools.Error("download expired", "url", url)
 			fmt.Fprintln(os.Stderr, "\nDownload Expired")
 			retry = maxRetry
 		}
 		retry++
 		if err == nil {
 			err = fmt.Errorf("status Code: %d, %s ", status, string(*data))
-		} else {
+		} else if status != 200 {
 			timeout += 30
 		}
 		if retry > maxRetry {
 			return
 		}
-		fmt.Fprintf(os.Stderr, "\n\033[2A\033[2KError: %v, Retrying...\n", tools.ANSIColor(tools.ShortenString(err, 40), 2))
+		tools.Emit(tools.EventRetry, map[string]any{"url": url, "status": status, "error": err.Error(), "attempt": retry})
+		tools.Warn("segment retry", "url", url, "status", status, "attempt", retry, "error", err)
+		metrics.Retries.Add(1, metrics.RetryStatus(status))
+		bar.Retry(err)
 		time.Sleep(time.Second)
 	}
 	return
@@ -0,0 +1,242 @@
+package recu
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"recurbate/playlist"
+	"recurbate/tools"
+	"recurbate/ts"
+)
+
+// Defines a damaged or missing part of a .ts file
+type Damage struct {
+	// index of the segment in the playlist, -1 if it is not known
+	Index  int
+	Offset int64
+	Size   int64
+	Reason string
+}
+
+// Walks the .ts file against the segments of the playlist using the segment index Mux records
+func Verify(tsPath string, playList playlist.Playlist) (damages []Damage, err error) {
+	file, err := os.Open(tsPath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	segments, _, err := ts.ReadIndex(ts.IndexPath(tsPath))
+	if os.IsNotExist(err) {
+		return scanPackets(file, info.Size())
+	} else if err != nil {
+		return
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("segment index is empty")
+	}
+	var expected int64 = segments[0].Offset
+	next := segments[0].Index
+	for _, segment := range segments {
+		// segments skipped between two recorded ones are missing
+		for ; next < segment.Index; next++ {
+			damages = append(damages, Damage{Index: next, Offset: expected, Reason: "missing"})
+		}
+		next = segment.Index + 1
+		damage := Damage{Index: segment.Index, Offset: segment.Offset, Size: segment.Size}
+		switch {
+		case segment.Index >= playList.Len():
+			damage.Reason = "not in playlist"
+		case segment.Offset != expected:
+			damage.Reason = fmt.Sprintf("expected at offset %d", expected)
+		case segment.Offset+segment.Size > info.Size():
+			damage.Reason = "truncated"
+		default:
+			data := make([]byte, segment.Size)
+			_, err = file.ReadAt(data, segment.Offset)
+			if err != nil {
+				return
+			}
+			err = ts.CheckPackets(data)
+			if err == nil {
+				err = ts.CheckContinuity(data)
+			}
+			if err != nil {
+				damage.Reason = err.Error()
+			}
+			err = nil
+		}
+		if damage.Reason != "" {
+			damages = append(damages, damage)
+		}
+		expected = segment.Offset + segment.Size
+	}
+	if expected < info.Size() {
+		damages = append(damages, Damage{Index: -1, Offset: expected, Size: info.Size() - expected, Reason: "data after the last segment"})
+	}
+	return
+}
+
+// Scans a file without a segment index for ranges of packets missing their sync byte
+func scanPackets(file *os.File, size int64) (damages []Damage, err error) {
+	buffer := make([]byte, ts.PacketSize*4096)
+	var offset int64
+	for offset < size {
+		n, err := file.ReadAt(buffer, offset)
+		if err != nil && err != io.EOF {
+			return damages, err
+		}
+		for i := 0; i < n; i += ts.PacketSize {
+			if buffer[i] == ts.SyncByte && i+ts.PacketSize <= n {
+				continue
+			}
+			end := int64(i + ts.PacketSize)
+			if end > int64(n) {
+				end = int64(n)
+			}
+			// merge with the previous damaged range if adjacent
+			last := len(damages) - 1
+			if last >= 0 && damages[last].Offset+damages[last].Size == offset+int64(i) {
+				damages[last].Size += end - int64(i)
+				continue
+			}
+			damages = append(damages, Damage{Index: -1, Offset: offset + int64(i), Size: end - int64(i), Reason: "missing sync byte"})
+		}
+		if n == 0 {
+			break
+		}
+		offset += int64(n)
+	}
+	return
+}
+
+// Re-downloads the damaged segments and splices them into the .ts file
+func Repair(tsPath string, playList playlist.Playlist, header map[string]string, damages []Damage) (err error) {
+	segments, complete, err := ts.ReadIndex(ts.IndexPath(tsPath))
+	if err != nil {
+		return fmt.Errorf("can not repair without a segment index: %v", err)
+	}
+	if len(segments) == 0 {
+		return fmt.Errorf("segment index is empty")
+	}
+	damaged := make(map[int]bool)
+	for _, damage := range damages {
+		if damage.Index >= 0 {
+			damaged[damage.Index] = true
+		}
+	}
+	recorded := make(map[int]ts.Segment)
+	first, last := segments[0].Index, segments[0].Index
+	for _, segment := range segments {
+		recorded[segment.Index] = segment
+		if segment.Index < first {
+			first = segment.Index
+		}
+		if segment.Index > last {
+			last = segment.Index
+		}
+	}
+	// the index must belong to the playlist, otherwise the file would be rebuilt from the wrong segments
+	if last >= playList.Len() || first > last {
+		return fmt.Errorf("segment index does not match the playlist: segments %d to %d, playlist has %d", first, last, playList.Len())
+	}
+	old, err := os.Open(tsPath)
+	if err != nil {
+		return
+	}
+	defer old.Close()
+	file, err := os.Create(tsPath + ".repair")
+	if err != nil {
+		return
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	index, err := os.Create(ts.IndexPath(tsPath) + ".repair")
+	if err != nil {
+		return
+	}
+	defer os.Remove(index.Name())
+	defer index.Close()
+	// the video id is kept for the archive
+	id, _, err := ts.ReadIndexVideo(ts.IndexPath(tsPath))
+	if err != nil {
+		return
+	}
+	if id != "" {
+		err = ts.WriteIndexVideo(index, id)
+		if err != nil {
+			return
+		}
+	}
+	var offset int64
+	var data []byte
+	written := make(map[int]bool)
+	bar := tools.NewBar(filepath.Base(tsPath))
+	defer bar.Done()
+	for i := first; i <= last; i++ {
+		if tools.Abort {
+			return fmt.Errorf("aborted")
+		}
+		segment, ok := recorded[i]
+		var size int64
+		if ok && !damaged[i] {
+			size, err = io.Copy(file, io.NewSectionReader(old, segment.Offset, segment.Size))
+		} else {
+			tools.Status("Repairing segment: %d", i)
+			err = downloadLoop(&data, playList.List[i], header, 10, 5, bar)
+			if err != nil {
+				tools.StatusEnd()
+				return fmt.Errorf("segment %d: %v", i, err)
+			}
+			var n int
+			n, err = file.Write(data)
+			size = int64(n)
+		}
+		if err != nil {
+			return
+		}
+		err = ts.WriteIndex(index, ts.Segment{Index: i, Offset: offset, Size: size, Duration: duration(playList, i)})
+		if err != nil {
+			return
+		}
+		offset += size
+		written[i] = true
+	}
+	// the rebuilt file replaces the original, so it must hold every segment the original had
+	for i := range recorded {
+		if !written[i] {
+			return fmt.Errorf("repaired file is missing segment %d, the original is kept", i)
+		}
+	}
+	if complete {
+		err = ts.EndIndex(index)
+		if err != nil {
+			return
+		}
+	}
+	tools.StatusLine("Repairing: Complete")
+	err = file.Sync()
+	if err != nil {
+		return
+	}
+	file.Close()
+	index.Close()
+	old.Close()
+	err = os.Rename(file.Name(), tsPath)
+	if err != nil {
+		return
+	}
+	return os.Rename(index.Name(), ts.IndexPath(tsPath))
+}
+
+// Returns the duration of the segment at the index of the playlist
+func duration(playList playlist.Playlist, index int) float64 {
+	if index < len(playList.Durations) {
+		return playList.Durations[index]
+	}
+	return 0
+}
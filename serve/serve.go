@@ -0,0 +1,154 @@
+package serve
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"recurbate/recu"
+	"recurbate/ts"
+	"sort"
+	"strings"
+)
+
+// Serves the output directory over HTTP, every download can be followed as an HLS playlist
+func Run(addr string, dir string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/files/", http.StripPrefix("/files/", videoFiles(http.FileServer(http.Dir(dir)))))
+	mux.HandleFunc("/live/", func(w http.ResponseWriter, r *http.Request) {
+		live(w, r, dir)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		list(w, dir)
+	})
+	fmt.Printf("Serving %v on http://%v/\n", dir, addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// Only serves video files and playlists, the directory also holds the json with the Cookie, the queue and logs
+func videoFiles(files http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Base(r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/") || !(strings.HasSuffix(name, ".ts") || strings.HasSuffix(name, ".ts.part") || strings.HasSuffix(name, ".m3u8")) {
+			http.NotFound(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// Defines a download found in the output directory
+type download struct {
+	Name     string
+	Playlist string
+	Complete bool
+}
+
+// Returns the downloads in the directory and its subdirectories, .ts files with a segment index and segment stores
+func downloads(dir string) (found []download) {
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case strings.HasSuffix(path, ".idx") || strings.HasSuffix(path, ".idx.part"):
+			name := strings.TrimSuffix(strings.TrimSuffix(rel, ".part"), ".idx")
+			_, complete, err := ts.ReadIndex(path)
+			if err != nil {
+				return nil
+			}
+			found = append(found, download{Name: name + ".ts", Playlist: "/live/" + escape(name) + ".m3u8", Complete: complete})
+		case entry.Name() == recu.StorePlaylist:
+			name := strings.TrimSuffix(rel, "/"+recu.StorePlaylist)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			found = append(found, download{Name: name, Playlist: "/files/" + escape(rel), Complete: strings.Contains(string(data), "#EXT-X-ENDLIST")})
+		}
+		return nil
+	})
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].Name < found[j].Name
+	})
+	return
+}
+
+// Escapes every element of a slash separated path
+func escape(path string) string {
+	split := strings.Split(path, "/")
+	for i, v := range split {
+		split[i] = url.PathEscape(v)
+	}
+	return strings.Join(split, "/")
+}
+
+// Writes a page linking the playlist of every download
+func list(w http.ResponseWriter, dir string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, "<!DOCTYPE html><html><head><title>Recu</title></head><body><h1>Downloads</h1><ul>")
+	for _, d := range downloads(dir) {
+		state := "downloading"
+		if d.Complete {
+			state = "complete"
+		}
+		fmt.Fprintf(w, `<li><a href="%s">%s</a> (%s)</li>`, html.EscapeString(d.Playlist), html.EscapeString(d.Name), state)
+	}
+	fmt.Fprint(w, "</ul></body></html>")
+}
+
+// Writes a playlist of the segments written so far to a .ts file, it is ended once the download is complete
+func live(w http.ResponseWriter, r *http.Request, dir string) {
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/live/"), ".m3u8")
+	// the name may hold directories but must stay inside the served directory
+	name = path.Clean("/" + name)[1:]
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	// downloads in progress may have a .part suffix
+	base := filepath.Join(dir, filepath.FromSlash(name))
+	suffix := ""
+	segments, complete, err := ts.ReadIndex(base + ".idx")
+	if os.IsNotExist(err) {
+		suffix = ".part"
+		segments, complete, err = ts.ReadIndex(base + ".idx.part")
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	target := 1
+	for _, segment := range segments {
+		if int(math.Ceil(segment.Duration)) > target {
+			target = int(math.Ceil(segment.Duration))
+		}
+	}
+	file := "/files/" + escape(name) + ".ts" + suffix
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n", target)
+	for i, segment := range segments {
+		if i > 0 && segments[i-1].Index != segment.Index-1 {
+			fmt.Fprint(w, "#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(w, "#EXTINF:%.3f,\n#EXT-X-BYTERANGE:%d@%d\n%s\n", segment.Duration, segment.Size, segment.Offset, file)
+	}
+	if complete {
+		fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// There is no SIGHUP, the json is still reloaded when it changes
+func reloadOnHangup() (stop func()) {
+	return func() {}
+}
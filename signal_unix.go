@@ -0,0 +1,54 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"recurbate/config"
+	"recurbate/recu"
+	"recurbate/tools"
+	"syscall"
+)
+
+// SIGUSR1 pauses every download and SIGUSR2 resumes them
+func init() {
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGUSR1, syscall.SIGUSR2)
+		for s := range sig {
+			switch s {
+			case syscall.SIGUSR1:
+				recu.PauseAll()
+				fmt.Println("Paused every download")
+			case syscall.SIGUSR2:
+				recu.ResumeAll()
+				fmt.Println("Resumed every download")
+			}
+			tools.Info("signal received", "signal", s)
+		}
+	}()
+}
+
+// Reloads the json on SIGHUP until the returned function is called, SIGHUP then stops the program again
+func reloadOnHangup() (stop func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sig:
+				tools.Info("signal received", "signal", syscall.SIGHUP)
+				config.RequestReload()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bytes reserved by downloads that are running
+var (
+	reserved    int64
+	reservedMtx sync.Mutex
+	reservedCnd = sync.NewCond(&reservedMtx)
+)
+
+// Parses a size such as 500MB or 2GB into bytes
+func ParseBytes(str string) (int64, error) {
+	str = strings.ToUpper(strings.TrimSpace(str))
+	units := []struct {
+		suffix string
+		size   float64
+	}{{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1}}
+	for _, unit := range units {
+		if strings.HasSuffix(str, unit.suffix) {
+			num, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(str, unit.suffix)), 64)
+			if err != nil {
+				return 0, fmt.Errorf("size %q is in wrong format", str)
+			}
+			return int64(num * unit.size), nil
+		}
+	}
+	num, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("size %q is in wrong format", str)
+	}
+	return num, nil
+}
+
+// Converts a number of bytes to a formated string
+func FormatBytes(num float64) string {
+	switch {
+	case num >= 1e9:
+		return fmt.Sprintf("%.1f GB", num/1e9)
+	case num >= 1e6:
+		return fmt.Sprintf("%.1f MB", num/1e6)
+	case num >= 1e3:
+		return fmt.Sprintf("%.1f KB", num/1e3)
+	}
+	return fmt.Sprintf("%.0f B", num)
+}
+
+// Returns the free space of the filesystem holding the path, or of its nearest existing parent
+func FreeSpace(path string) (int64, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return freeSpace(path)
+}
+
+// Reserves the size on the filesystem of the path while keeping minFree bytes free,
+// waits for other downloads to finish if there is not enough space, refuses if none are running
+func Reserve(path string, size, minFree int64) error {
+	reservedMtx.Lock()
+	defer reservedMtx.Unlock()
+	waiting := false
+	for !Abort {
+		free, err := FreeSpace(path)
+		if err != nil {
+			// the free space can not be checked on every system
+			return nil
+		}
+		if free-reserved-size >= minFree {
+			reserved += size
+			return nil
+		}
+		if reserved == 0 {
+			return fmt.Errorf("needs about %s but only %s is free and %s must stay free", FormatBytes(float64(size)), FormatBytes(float64(free)), FormatBytes(float64(minFree)))
+		}
+		if !waiting {
+			fmt.Printf("Queued: needs about %s, waiting for other downloads to finish\n", FormatBytes(float64(size)))
+			waiting = true
+		}
+		reservedCnd.Wait()
+	}
+	return fmt.Errorf("aborted")
+}
+
+// Releases bytes reserved by a download, once written or when it stops
+func Release(size int64) {
+	reservedMtx.Lock()
+	reserved -= size
+	reservedMtx.Unlock()
+	reservedCnd.Broadcast()
+}
+
+// Blocks while the filesystem of the path has less than minFree bytes free
+func WaitForSpace(path string, minFree int64) {
+	paused := false
+	for !Abort {
+		free, err := FreeSpace(path)
+		if err != nil || free >= minFree {
+			break
+		}
+		if !paused {
+			fmt.Fprintf(os.Stderr, "\nPaused: only %s free, waiting for %s\n", FormatBytes(float64(free)), FormatBytes(float64(minFree)))
+			paused = true
+		}
+		time.Sleep(10 * time.Second)
+	}
+	if paused {
+		fmt.Fprintln(os.Stderr, "Resumed: enough disk space")
+	}
+}
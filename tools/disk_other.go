@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package tools
+
+import "fmt"
+
+func freeSpace(path string) (int64, error) {
+	return 0, fmt.Errorf("free space is not supported on this system")
+}
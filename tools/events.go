@@ -0,0 +1,55 @@
+package tools
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+var (
+	// set by --output=json, events are written to stdout as JSON lines and every other message to stderr
+	JSONOutput bool
+	// time between progress events
+	ProgressInterval = 5 * time.Second
+	eventMtx         sync.Mutex
+)
+
+// Event names, every event has a time and an event field, the other fields are listed next to the event
+const (
+	// url
+	EventResolveStart = "resolve_start"
+	// url, filename, segments, duration, error and class on failure
+	EventResolveEnd = "resolve_end"
+	// url, quality, bandwidth, playlist
+	EventVariant = "variant"
+	// filename, index, end, percent, speed, remaining, bytes
+	EventProgress = "progress"
+	// url, status, error, attempt
+	EventRetry = "retry"
+	// url, filename, path, segments, bytes, duration
+	EventCompleted = "completed"
+	// url, filename, index, error, class
+	EventFailed = "failed"
+	// version, url
+	EventUpdate = "update_available"
+)
+
+// Writes an event as a JSON line to stdout when the output is JSON
+func Emit(event string, fields map[string]any) {
+	if !JSONOutput {
+		return
+	}
+	line := make(map[string]any, len(fields)+2)
+	for k, v := range fields {
+		line[k] = v
+	}
+	line["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	line["event"] = event
+	data, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	eventMtx.Lock()
+	defer eventMtx.Unlock()
+	Stdout.Write(append(data, '\n'))
+}
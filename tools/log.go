@@ -0,0 +1,200 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defines the severity of a log record
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (level Level) String() string {
+	switch level {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	}
+	return "error"
+}
+
+// Returns the level named debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+	for level := LevelDebug; level <= LevelError; level++ {
+		if strings.EqualFold(name, level.String()) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %v", name)
+}
+
+// writes leveled records, nothing is logged until SetupLog is called
+var logger struct {
+	mtx   sync.Mutex
+	out   io.Writer
+	level Level
+	// writes to os.Stderr as it is when the record is logged, it is replaced while progress is drawn
+	stderr bool
+}
+
+// Logs to the file, or to stderr if path is empty, records below the level are dropped.
+// The file is rotated once it grows past maxSize, keeping backups old files as path.1, path.2 and so on
+func SetupLog(path string, level Level, maxSize int64, backups int) error {
+	logger.mtx.Lock()
+	defer logger.mtx.Unlock()
+	logger.level = level
+	if path == "" {
+		logger.stderr = true
+		return nil
+	}
+	file := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	err := file.open()
+	if err != nil {
+		return err
+	}
+	logger.out = file
+	return nil
+}
+
+// Logs a debug record, kv are key value pairs
+func Debug(msg string, kv ...any) {
+	logRecord(LevelDebug, msg, kv)
+}
+
+// Logs an info record, kv are key value pairs
+func Info(msg string, kv ...any) {
+	logRecord(LevelInfo, msg, kv)
+}
+
+// Logs a warning record, kv are key value pairs
+func Warn(msg string, kv ...any) {
+	logRecord(LevelWarn, msg, kv)
+}
+
+// Logs an error record, kv are key value pairs
+func Error(msg string, kv ...any) {
+	logRecord(LevelError, msg, kv)
+}
+
+// Writes a record as a line of key=value pairs
+func logRecord(level Level, msg string, kv []any) {
+	logger.mtx.Lock()
+	defer logger.mtx.Unlock()
+	out := logger.out
+	if logger.stderr {
+		out = os.Stderr
+	}
+	if out == nil || level < logger.level {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "time=%s level=%s msg=%s", time.Now().Format("2006-01-02T15:04:05.000Z07:00"), level, quote(msg))
+	for i := 0; i < len(kv); i += 2 {
+		value := "!missing"
+		if i+1 < len(kv) {
+			value = formatValue(kv[i+1])
+		}
+		fmt.Fprintf(&b, " %v=%s", kv[i], value)
+	}
+	b.WriteString("\n")
+	io.WriteString(out, b.String())
+}
+
+// Returns the value quoted if needed
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case time.Duration:
+		return v.String()
+	case error:
+		return quote(v.Error())
+	case map[string]string:
+		return quote(fmt.Sprint(RedactHeader(v)))
+	}
+	return quote(fmt.Sprint(value))
+}
+
+func quote(str string) string {
+	if str == "" || strings.ContainsAny(str, " \t\n\r\"=") {
+		return strconv.Quote(str)
+	}
+	return str
+}
+
+// Returns a copy of the header with its credentials redacted
+func RedactHeader(header map[string]string) map[string]string {
+	redacted := make(map[string]string, len(header))
+	for k, v := range header {
+		switch strings.ToLower(k) {
+		case "cookie", "authorization":
+			v = "[redacted]"
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// Defines a log file rotated by size
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+// Opens the log file for appending
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("can not open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+// Writes to the log file, rotating it first if the write would make it too large
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err := r.rotate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file: %v\n", err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Moves path to path.1, path.1 to path.2 and so on, dropping the oldest, and opens a new file
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+	if r.backups <= 0 {
+		os.Remove(r.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.backups))
+		for i := r.backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		os.Rename(r.path, r.path+".1")
+	}
+	return r.open()
+}
@@ -0,0 +1,256 @@
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defines the progress line of a download
+type Bar struct {
+	id        int
+	name      string
+	percent   float64
+	remaining float64
+	speed     float64
+	message   string
+	paused    bool
+	// the line is drawn on the terminal and not ended yet
+	drawn bool
+	// last time the line was printed in plain mode
+	printed time.Time
+}
+
+// time between progress lines in plain mode
+const plainInterval = 10 * time.Second
+
+// draws a line for every download below the rest of the output
+var progress struct {
+	mtx     sync.Mutex
+	active  bool
+	bars    []*Bar
+	pending []string
+	// lines drawn by the last draw
+	drawn int
+	// id of the last bar
+	lastId int
+	out    *os.File
+	stdout *os.File
+	stderr *os.File
+	done   chan struct{}
+}
+
+var escapeRegex = regexp.MustCompile(`\033\[[0-9;]*[A-Za-z]`)
+
+// Starts drawing a progress line for every download and the total speed below the output.
+// Until the returned function is called, os.Stdout and os.Stderr are read and printed above the lines
+func StartProgress() (stop func()) {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+	// plain output prints every line as it comes
+	if progress.active || Plain {
+		return func() {}
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return func() {}
+	}
+	progress.active = true
+	progress.out = os.Stdout
+	progress.stdout, progress.stderr = os.Stdout, os.Stderr
+	progress.done = make(chan struct{})
+	os.Stdout, os.Stderr = w, w
+	readDone := make(chan struct{})
+	go readOutput(r, readDone)
+	go func() {
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-progress.done:
+				return
+			case <-ticker.C:
+				progress.mtx.Lock()
+				draw()
+				progress.mtx.Unlock()
+			}
+		}
+	}()
+	return func() {
+		progress.mtx.Lock()
+		os.Stdout, os.Stderr = progress.stdout, progress.stderr
+		close(progress.done)
+		progress.mtx.Unlock()
+		w.Close()
+		<-readDone
+		progress.mtx.Lock()
+		defer progress.mtx.Unlock()
+		progress.bars = nil
+		draw()
+		progress.active = false
+	}
+}
+
+// Reads the output written while the progress is drawn, one line at a time
+func readOutput(r io.ReadCloser, done chan struct{}) {
+	defer close(done)
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// only the last part of a line that was rewritten is kept
+		if i := strings.LastIndex(line, "\r"); i != -1 {
+			line = line[i+1:]
+		}
+		if strings.TrimSpace(escapeRegex.ReplaceAllString(line, "")) == "" {
+			continue
+		}
+		progress.mtx.Lock()
+		progress.pending = append(progress.pending, line)
+		progress.mtx.Unlock()
+	}
+}
+
+// Redraws the progress lines below the output printed since the last draw, must hold mtx
+func draw() {
+	var b strings.Builder
+	if progress.drawn > 0 {
+		fmt.Fprintf(&b, "\033[%dA", progress.drawn)
+	}
+	b.WriteString("\r\033[J")
+	for _, line := range progress.pending {
+		b.WriteString(line + "\033[0m\n")
+	}
+	progress.pending = nil
+	progress.drawn = 0
+	var speed float64
+	for _, bar := range progress.bars {
+		b.WriteString(bar.line() + "\n")
+		speed += bar.speed
+		progress.drawn++
+	}
+	if len(progress.bars) > 1 {
+		fmt.Fprintf(&b, "Total: %d downloads\t%s\n", len(progress.bars), FormatBytesPerSecond(speed))
+		progress.drawn++
+	}
+	progress.out.WriteString(b.String())
+}
+
+// Returns the line of the bar, short enough not to wrap
+func (bar *Bar) line() string {
+	name := []rune(bar.name)
+	if len(name) > 32 {
+		name = append(name[:31], '…')
+	}
+	line := fmt.Sprintf("[%d] %-32s %s\t%s\t%s", bar.id, string(name), ANSIColor(fmt.Sprintf("%5.1f%%", bar.percent), 33), FormatMinutes(bar.remaining), FormatBytesPerSecond(bar.speed))
+	if bar.paused {
+		line += "\t" + ANSIColor("Paused", 2)
+	} else if bar.message != "" {
+		line += "\t" + ANSIColor(ShortenString(bar.message, 40), 2)
+	}
+	return line
+}
+
+// Returns a progress line for the download, it is drawn once the progress is started
+func NewBar(name string) *Bar {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+	progress.lastId++
+	bar := &Bar{id: progress.lastId, name: name}
+	if progress.active {
+		progress.bars = append(progress.bars, bar)
+	}
+	return bar
+}
+
+// Updates the percent done, minutes remaining and bytes per second of the download
+func (bar *Bar) Update(percent, remaining, speed float64) {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+	bar.percent, bar.remaining, bar.speed = percent, remaining, speed
+	bar.message = ""
+	switch {
+	case progress.active:
+	case Plain:
+		if time.Since(bar.printed) < plainInterval {
+			return
+		}
+		bar.printed = time.Now()
+		fmt.Printf("%s %s: Downloading: %.1f%%\tRemaining: %s\t%s\n", bar.printed.Format("2006/01/02 15:04:05"), bar.name, percent, FormatMinutes(remaining), FormatBytesPerSecond(speed))
+	default:
+		bar.drawn = true
+		fmt.Printf("\n\033[A\033[2KDownloading: %s\tRemaining: %s\t%s", ANSIColor(fmt.Sprintf("%.1f%%", percent), 33), FormatMinutes(remaining), FormatBytesPerSecond(speed))
+	}
+}
+
+// Shows a retry error on the line of the download until its next update
+func (bar *Bar) Retry(err any) {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+	bar.message = fmt.Sprintf("Error: %v, Retrying...", err)
+	switch {
+	case progress.active:
+	case Plain:
+		fmt.Fprintf(os.Stderr, "%s %s: Error: %v, Retrying...\n", time.Now().Format("2006/01/02 15:04:05"), bar.name, ShortenString(err, 200))
+	default:
+		fmt.Fprintf(os.Stderr, "\n\033[2A\033[2KError: %v, Retrying...\n", ANSIColor(ShortenString(err, 40), 2))
+	}
+}
+
+// Returns the number shown on the line of the download
+func (bar *Bar) Id() int {
+	return bar.id
+}
+
+// Shows the download as paused until it is resumed
+func (bar *Bar) SetPaused(paused bool) {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+	bar.paused = paused
+	state := "Resumed"
+	if paused {
+		state = "Paused"
+	}
+	switch {
+	case progress.active:
+	case Plain:
+		fmt.Printf("%s %s: %s\n", time.Now().Format("2006/01/02 15:04:05"), bar.name, state)
+	default:
+		bar.drawn = true
+		fmt.Printf("\n\033[A\033[2KDownloading: [%d] %s", bar.id, state)
+	}
+}
+
+// Ends the line drawn by the last update so other messages are printed below it
+func (bar *Bar) Break() {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+	bar.breakLine()
+}
+
+// Ends the drawn line, must hold mtx
+func (bar *Bar) breakLine() {
+	if bar.drawn && !progress.active {
+		fmt.Println()
+	}
+	bar.drawn = false
+}
+
+// Ends and removes the line of the download
+func (bar *Bar) Done() {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+	bar.breakLine()
+	for i, b := range progress.bars {
+		if b == bar {
+			progress.bars = append(progress.bars[:i], progress.bars[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !windows
+
+package tools
+
+import (
+	"fmt"
+	"os"
+)
+
+func makeRaw(file *os.File) (restore func(), err error) {
+	return nil, fmt.Errorf("raw terminal input is not supported on this system")
+}
@@ -0,0 +1,38 @@
+//go:build linux || darwin
+
+package tools
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Makes the terminal send every key as it is typed without echoing it, Ctrl+C still interrupts.
+// The returned function restores the terminal
+func makeRaw(file *os.File) (restore func(), err error) {
+	var old syscall.Termios
+	err = ioctl(file.Fd(), getTermios, &old)
+	if err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	err = ioctl(file.Fd(), setTermios, &raw)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ioctl(file.Fd(), setTermios, &old)
+	}, nil
+}
+
+func ioctl(fd uintptr, request uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package tools
+
+import (
+	"os"
+	"syscall"
+)
+
+var setConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+const (
+	enableLineInput = 0x2
+	enableEchoInput = 0x4
+)
+
+// Makes the console send every key as it is typed without echoing it, Ctrl+C still interrupts.
+// The returned function restores the console
+func makeRaw(file *os.File) (restore func(), err error) {
+	handle := syscall.Handle(file.Fd())
+	var old uint32
+	err = syscall.GetConsoleMode(handle, &old)
+	if err != nil {
+		return nil, err
+	}
+	ret, _, err := setConsoleMode.Call(uintptr(handle), uintptr(old&^(enableLineInput|enableEchoInput)))
+	if ret == 0 {
+		return nil, err
+	}
+	return func() {
+		setConsoleMode.Call(uintptr(handle), uintptr(old))
+	}, nil
+}
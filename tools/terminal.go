@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+	// set when stdout is not a terminal, progress is printed as timestamped lines instead of redrawn
+	Plain bool
+	// set when ANSIColor may color its output
+	Color = true
+	// restores the terminal put in raw mode by ReadKeys
+	restoreTerminal func()
+	rawMtx          sync.Mutex
+)
+
+// Detects whether stdout is a terminal, color is disabled by NO_COLOR, --no-color or plain output
+func SetupTerminal() {
+	Plain = !IsTerminal(os.Stdout)
+	_, noColor := Option("no-color")
+	Color = !Plain && !noColor && os.Getenv("NO_COLOR") == ""
+}
+
+// Returns whether the file is a terminal
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Rewrites the current line of the terminal, nothing is printed in plain mode
+func Status(format string, a ...any) {
+	if Plain {
+		return
+	}
+	fmt.Printf("\r\033[2K"+format, a...)
+}
+
+// Rewrites the current line of the terminal and ends it, in plain mode the line is printed on its own
+func StatusLine(format string, a ...any) {
+	if Plain {
+		fmt.Printf(format+"\n", a...)
+		return
+	}
+	fmt.Printf("\r\033[2K"+format+"\n", a...)
+}
+
+// Ends the line left open by Status
+func StatusEnd() {
+	if !Plain {
+		fmt.Println()
+	}
+}
+
+// Puts the terminal of stdin in raw mode and sends every key typed to the returned channel.
+// RestoreTerminal must be called before exiting
+func ReadKeys() (<-chan byte, error) {
+	rawMtx.Lock()
+	defer rawMtx.Unlock()
+	if restoreTerminal != nil {
+		return nil, fmt.Errorf("keys are already read")
+	}
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	restoreTerminal = restore
+	keys := make(chan byte)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 1)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			if n == 1 {
+				keys <- buf[0]
+			}
+		}
+	}()
+	return keys, nil
+}
+
+// Restores the terminal put in raw mode by ReadKeys
+func RestoreTerminal() {
+	rawMtx.Lock()
+	defer rawMtx.Unlock()
+	if restoreTerminal != nil {
+		restoreTerminal()
+		restoreTerminal = nil
+	}
+}
@@ -13,6 +13,9 @@ import (
 
 var Abort bool
 
+// stdout of the process, kept when os.Stdout is redirected to stderr
+var Stdout = os.Stdout
+
 // Check for update
 func CheckUpdate(currentTag string) (err error) {
 	defer func() {
@@ -50,6 +53,7 @@ func CheckUpdate(currentTag string) (err error) {
 			continue
 		}
 		if new > current {
+			Emit(EventUpdate, map[string]any{"version": "v" + newTag, "url": resp.(map[string]any)["html_url"]})
 			fmt.Printf("New Update Available: v%s\n", newTag)
 			fmt.Printf("%s\n%s\n", resp.(map[string]any)["html_url"].(string), ANSIColor(resp.(map[string]any)["body"].(string), 2))
 			return nil
@@ -60,9 +64,15 @@ func CheckUpdate(currentTag string) (err error) {
 
 // Returns the raw data from the URL
 func Request(url string, timeout int, header map[string]string, body []byte, Type string) ([]byte, int, error) {
+	data, status, _, err := RequestHeader(url, timeout, header, body, Type)
+	return data, status, err
+}
+
+// Returns the raw data and the response headers from the URL
+func RequestHeader(url string, timeout int, header map[string]string, body []byte, Type string) ([]byte, int, http.Header, error) {
 	req, err := http.NewRequest(Type, url, strings.NewReader(string(body)))
 	if err != nil {
-		return nil, 0, fmt.Errorf("http.NewRequest:%v", err)
+		return nil, 0, nil, fmt.Errorf("http.NewRequest:%v", err)
 	}
 	for key, value := range header {
 		req.Header.Set(key, value)
@@ -70,28 +80,62 @@ func Request(url string, timeout int, header map[string]string, body []byte, Typ
 	client := &http.Client{
 		Timeout: time.Duration(timeout) * time.Second,
 	}
+	start := time.Now()
 	data, err := client.Do(req)
 	if err != nil {
-		return nil, 0, fmt.Errorf("client.Do:%v", err)
+		Debug("request failed", "method", Type, "url", url, "took", time.Since(start), "error", err)
+		return nil, 0, nil, fmt.Errorf("client.Do:%v", err)
 	}
 	defer data.Body.Close()
 	databytes, err := io.ReadAll(data.Body)
+	Debug("request", "method", Type, "url", url, "status", data.StatusCode, "bytes", len(databytes), "took", time.Since(start))
 	if err != nil {
-		return nil, data.StatusCode, fmt.Errorf("io.ReadAll:%v", err)
+		return nil, data.StatusCode, data.Header, fmt.Errorf("io.ReadAll:%v", err)
 	}
-	return databytes, data.StatusCode, nil
+	return databytes, data.StatusCode, data.Header, nil
 }
 
-// Parses executatables arguments to prevent runtime errors
+// Parses executatables arguments to prevent runtime errors, options starting with "--" are skipped
 func Argparser(n int) string {
-	if len(os.Args) > n {
-		return os.Args[n]
+	args := make([]string, 0, len(os.Args))
+	for i, arg := range os.Args {
+		if i > 0 && strings.HasPrefix(arg, "--") {
+			continue
+		}
+		args = append(args, arg)
+	}
+	if len(args) > n {
+		return args[n]
 	}
 	return ""
 }
 
-// ANSI Color
+// Returns the values of every --name=value option given, a bare --name has the value "true"
+func Options(name string) (values []string) {
+	for _, arg := range os.Args[1:] {
+		if arg == "--"+name {
+			values = append(values, "true")
+		} else if strings.HasPrefix(arg, "--"+name+"=") {
+			values = append(values, arg[len(name)+3:])
+		}
+	}
+	return
+}
+
+// Returns the value of the last --name option given
+func Option(name string) (value string, ok bool) {
+	values := Options(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// ANSI Color, the string is returned as is when color is disabled
 func ANSIColor(str any, mod int, color ...int) (final string) {
+	if !Color {
+		return fmt.Sprint(str)
+	}
 	var x, r, g, b int
 	var rgb bool
 	if len(color) == 1 {
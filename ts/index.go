@@ -0,0 +1,117 @@
+package ts
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Defines where a segment of the playlist was written in the .ts file
+type Segment struct {
+	Index    int
+	Offset   int64
+	Size     int64
+	Duration float64
+}
+
+// line written to the index once the download is complete
+const indexEnd = "end"
+
+// starts the line of the index holding the id of the video
+const indexVideo = "video "
+
+// Returns the location of the segment index kept next to the .ts file
+func IndexPath(tsPath string) string {
+	return strings.TrimSuffix(tsPath, ".ts") + ".idx"
+}
+
+// Reads the segment index, each line holds the segment index, offset, size and duration,
+// complete is set if the download has finished
+func ReadIndex(path string) (segments []Segment, complete bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if scanner.Text() == indexEnd {
+			complete = true
+			continue
+		}
+		var segment Segment
+		n, _ := fmt.Sscan(scanner.Text(), &segment.Index, &segment.Offset, &segment.Size, &segment.Duration)
+		// indexes of older versions have no duration
+		if n < 3 {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	err = scanner.Err()
+	return
+}
+
+// Returns the video id recorded in the segment index, empty for indexes of older versions,
+// and whether the download has finished
+func ReadIndexVideo(path string) (id string, complete bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		switch line := scanner.Text(); {
+		case line == indexEnd:
+			complete = true
+		case strings.HasPrefix(line, indexVideo):
+			id = strings.TrimPrefix(line, indexVideo)
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// Records the id of the video in the index, segment lines are not affected by it
+func WriteIndexVideo(file *os.File, id string) error {
+	_, err := fmt.Fprintln(file, indexVideo+id)
+	return err
+}
+
+// Writes the segment to the index
+func WriteIndex(file *os.File, segment Segment) error {
+	_, err := fmt.Fprintf(file, "%d %d %d %.3f\n", segment.Index, segment.Offset, segment.Size, segment.Duration)
+	return err
+}
+
+// Marks the download of the index as complete
+func EndIndex(file *os.File) error {
+	_, err := fmt.Fprintln(file, indexEnd)
+	return err
+}
+
+// Checks that the continuity counters of every PID increase by one from packet to packet
+func CheckContinuity(data []byte) error {
+	last := make(map[int]int)
+	for i := 0; i+PacketSize <= len(data); i += PacketSize {
+		packet := data[i : i+PacketSize]
+		pid := PID(packet)
+		// null packets and packets without payload do not increase the counter
+		if pid == 0x1fff || !HasPayload(packet) {
+			continue
+		}
+		// the discontinuity indicator resets the counter
+		if packet[3]&0x20 != 0 && packet[4] > 0 && packet[5]&0x80 != 0 {
+			delete(last, pid)
+		}
+		cc := Continuity(packet)
+		prev, ok := last[pid]
+		last[pid] = cc
+		if !ok || cc == prev || cc == (prev+1)%16 {
+			continue
+		}
+		return fmt.Errorf("continuity error on PID %d at packet %d", pid, i/PacketSize)
+	}
+	return nil
+}
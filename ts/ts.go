@@ -0,0 +1,157 @@
+package ts
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	PacketSize = 188
+	SyncByte   = 0x47
+	// smallest plausible size of a segment, about half a second of low quality video
+	MinSegmentSize = PacketSize * 64
+)
+
+// Checks that the data consists of whole packets each starting with the sync byte
+func CheckPackets(data []byte) error {
+	if len(data)%PacketSize != 0 {
+		return fmt.Errorf("length %d is not a multiple of %d", len(data), PacketSize)
+	}
+	for i := 0; i < len(data); i += PacketSize {
+		if data[i] != SyncByte {
+			return fmt.Errorf("missing sync byte at packet %d", i/PacketSize)
+		}
+	}
+	return nil
+}
+
+// Checks that the file is plausibly a complete download of the number of segments
+func VerifyFile(path string, segments int) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	size := info.Size()
+	if size < int64(segments)*MinSegmentSize {
+		return fmt.Errorf("size %d is too small for %d segments", size, segments)
+	}
+	if size%PacketSize != 0 {
+		return fmt.Errorf("size %d is not a multiple of %d", size, PacketSize)
+	}
+	// check the sync bytes of the last packets
+	tail := int64(PacketSize * 64)
+	if tail > size {
+		tail = size
+	}
+	data := make([]byte, tail)
+	_, err = file.ReadAt(data, size-tail)
+	if err != nil && err != io.EOF {
+		return
+	}
+	err = CheckPackets(data)
+	if err != nil {
+		return fmt.Errorf("tail of file is corrupt: %v", err)
+	}
+	return nil
+}
+
+// Returns the packet identifier of the packet
+func PID(packet []byte) int {
+	return int(packet[1]&0x1f)<<8 | int(packet[2])
+}
+
+// Returns the continuity counter of the packet
+func Continuity(packet []byte) int {
+	return int(packet[3] & 0x0f)
+}
+
+// Returns whether the packet carries a payload
+func HasPayload(packet []byte) bool {
+	return packet[3]&0x10 != 0
+}
+
+// Returns the payload of the packet, skipping the adaptation field
+func Payload(packet []byte) []byte {
+	start := 4
+	if packet[3]&0x20 != 0 {
+		start += 1 + int(packet[4])
+	}
+	if !HasPayload(packet) || start >= len(packet) {
+		return nil
+	}
+	return packet[start:]
+}
+
+// Returns the PMT PIDs listed in the first PAT of the data
+func pmtPids(data []byte) (pids map[int]bool) {
+	for i := 0; i+PacketSize <= len(data); i += PacketSize {
+		packet := data[i : i+PacketSize]
+		// the PAT starts in a packet with PID 0 and the payload unit start indicator set
+		if PID(packet) != 0 || packet[1]&0x40 == 0 {
+			continue
+		}
+		payload := Payload(packet)
+		if len(payload) < 1 || int(payload[0])+1 >= len(payload) {
+			continue
+		}
+		section := payload[1+int(payload[0]):]
+		if len(section) < 8 || section[0] != 0 {
+			continue
+		}
+		length := int(section[1]&0x0f)<<8 | int(section[2])
+		// entries lie between the 8 byte header and the 4 byte CRC
+		end := 3 + length - 4
+		if end > len(section) {
+			end = len(section)
+		}
+		pids = make(map[int]bool)
+		for j := 8; j+4 <= end; j += 4 {
+			program := int(section[j])<<8 | int(section[j+1])
+			if program != 0 {
+				pids[int(section[j+2]&0x1f)<<8|int(section[j+3])] = true
+			}
+		}
+		return
+	}
+	return nil
+}
+
+// Checks that a downloaded segment is a transport stream holding a PAT and a PMT
+func ValidateSegment(data []byte, header http.Header) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty segment")
+	}
+	// error pages, .ts is sometimes mislabeled as typescript so other types are left to the packet checks
+	contentType := header.Get("Content-Type")
+	for _, page := range []string{"text/html", "text/plain", "application/json", "application/xhtml"} {
+		if strings.HasPrefix(contentType, page) {
+			return fmt.Errorf("content type is %v", contentType)
+		}
+	}
+	if length := header.Get("Content-Length"); length != "" && length != strconv.Itoa(len(data)) {
+		return fmt.Errorf("content length is %v but received %d bytes", length, len(data))
+	}
+	err := CheckPackets(data)
+	if err != nil {
+		return err
+	}
+	pmts := pmtPids(data)
+	if len(pmts) == 0 {
+		return fmt.Errorf("no PAT found")
+	}
+	for i := 0; i < len(data); i += PacketSize {
+		if pmts[PID(data[i:i+PacketSize])] {
+			return nil
+		}
+	}
+	return fmt.Errorf("no PMT found")
+}